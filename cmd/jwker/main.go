@@ -5,16 +5,24 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/nais/jwker/controllers"
 	"github.com/nais/jwker/pkg/config"
+	"github.com/nais/jwker/pkg/dryrun"
+	"github.com/nais/jwker/pkg/health"
+	"github.com/nais/jwker/pkg/jwk"
 	jwkermetrics "github.com/nais/jwker/pkg/metric"
+	"github.com/nais/jwker/pkg/tokendings"
 	jwkerv1 "github.com/nais/liberator/pkg/apis/nais.io/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	kevents "k8s.io/client-go/tools/events"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	ctrlmetricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	// +kubebuilder:scaffold:imports
@@ -25,11 +33,27 @@ var scheme = runtime.NewScheme()
 func init() {
 	// Register custom metrics with the global prometheus registry
 	metrics.Registry.MustRegister(
+		jwkermetrics.ClientJwkLastReloadSuccessTimestamp,
+		jwkermetrics.ClientJwkReloadCount,
+		jwkermetrics.DryRunChangesCount,
 		jwkermetrics.JwkersTotal,
 		jwkermetrics.JwkersProcessedCount,
 		jwkermetrics.JwkersFinalizedCount,
 		jwkermetrics.JwkerSecretsTotal,
 		jwkermetrics.JwkersProcessingFailedCount,
+		jwkermetrics.JwkersResyncedCount,
+		jwkermetrics.JwkersResyncPending,
+		jwkermetrics.TokendingsAuthTokenExpirySeconds,
+		jwkermetrics.TokendingsCircuitBreakerState,
+		jwkermetrics.TokendingsDecommissionedCount,
+		jwkermetrics.TokendingsDecommissionPending,
+		jwkermetrics.TokendingsDriftRepairedCount,
+		jwkermetrics.TokendingsHealthy,
+		jwkermetrics.TokendingsMetadataChangedCount,
+		jwkermetrics.TokendingsMetadataResolved,
+		jwkermetrics.TokendingsMetadataSecretsUpdatedCount,
+		jwkermetrics.TokendingsPrimaryChangedCount,
+		jwkermetrics.TokendingsRegistrationsSkippedCount,
 	)
 
 	_ = clientgoscheme.AddToScheme(scheme)
@@ -52,14 +76,27 @@ func main() {
 		os.Exit(1)
 	}
 
+	signingKeys := cfg.ClientKeys.Keys()
+	signingAlgorithm, _ := jwk.SigningAlgorithm(&signingKeys.Active)
+	log.Info(fmt.Sprintf("signing with client JWK %q using %s; configured key IDs: %v", signingKeys.Active.KeyID, signingAlgorithm, signingKeys.KeyIDs()))
+	if path := cfg.ClientKeys.Path(); path != "" {
+		log.Info(fmt.Sprintf("reloading client JWK from %q when it changes", path))
+	}
 	log.Info(fmt.Sprintf("resolved %d Tokendings instances:", len(cfg.TokendingsInstances)))
 	for i, instance := range cfg.TokendingsInstances {
-		log.Info(fmt.Sprintf("instance %d: baseURL=%q, clientID=%q", i+1, instance.BaseURL, instance.ClientID))
+		log.Info(fmt.Sprintf("instance %d: baseURL=%q, clientID=%q, resolved=%t, authenticator=%T", i+1, instance.BaseURL, instance.ClientID, instance.Metadata.Resolved(), instance.Authenticator))
+	}
+	for _, instance := range cfg.TokendingsDecommissionedInstances {
+		log.Info(fmt.Sprintf("decommissioning instance: baseURL=%q", instance.BaseURL))
 	}
 	if cfg.AuthTokenPath != "" {
 		log.Info(fmt.Sprintf("using service account token for Tokendings authentication from %q", cfg.AuthTokenPath))
 	}
 
+	if cfg.DryRun != nil {
+		log.Info("dry run: changes to Kubernetes and Tokendings are logged, but not made")
+	}
+
 	log.Info("starting jwker")
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
@@ -82,24 +119,112 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	watchdog := health.NewWatchdog(cfg.LivenessReconcileTimeout)
+	if err := mgr.AddHealthzCheck("reconcile-queue", watchdog.Check); err != nil {
+		log.Error("unable to set up health check", "error", err)
+		os.Exit(1)
+	}
+
+	prober := &tokendings.Prober{
+		Instances: cfg.TokendingsInstances,
+		Interval:  cfg.TokendingsProbeInterval,
+		Timeout:   cfg.TokendingsHTTP.Timeout,
+		Policy:    cfg.TokendingsReadinessPolicy,
+		OnProbe: func(instance tokendings.Instance, err error) {
+			if err != nil {
+				slog.Warn(fmt.Sprintf("probing tokendings instance %s", instance.BaseURL), "error", err)
+				jwkermetrics.TokendingsHealthy.WithLabelValues(instance.BaseURL).Set(0)
+				return
+			}
+			jwkermetrics.TokendingsHealthy.WithLabelValues(instance.BaseURL).Set(1)
+		},
+	}
+	if err := mgr.Add(prober); err != nil {
+		log.Error("unable to set up tokendings prober", "error", err)
+		os.Exit(1)
+	}
+
+	if err := mgr.AddReadyzCheck("tokendings", prober.Check); err != nil {
+		log.Error("unable to set up ready check", "error", err)
+		os.Exit(1)
+	}
+
+	if err := mgr.AddReadyzCheck("tokendings-circuit-breakers", tokendings.CircuitBreakerCheck(cfg.TokendingsInstances)); err != nil {
 		log.Error("unable to set up ready check", "error", err)
 		os.Exit(1)
 	}
 
+	k8sClient := mgr.GetClient()
+	var recorder kevents.EventRecorder = mgr.GetEventRecorder("Jwker")
+	if cfg.DryRun != nil {
+		k8sClient = dryrun.NewClient(k8sClient, cfg.DryRun)
+		recorder = dryrun.EventRecorder{Recorder: cfg.DryRun}
+	}
+
 	if err = (&controllers.JwkerReconciler{
-		Client:   mgr.GetClient(),
+		Client:   k8sClient,
 		Config:   cfg,
 		Reader:   mgr.GetAPIReader(),
-		Recorder: mgr.GetEventRecorder("Jwker"),
+		Recorder: recorder,
 		Scheme:   mgr.GetScheme(),
+		Watchdog: watchdog,
 	}).SetupWithManager(mgr); err != nil {
 		log.Error("unable to create controller", "controller", "jwker", "error", err)
 		os.Exit(1)
 	}
 
+	if err := mgr.Add(cfg.ClientKeys); err != nil {
+		log.Error("unable to set up client JWK reloading", "error", err)
+		os.Exit(1)
+	}
+
+	for _, certificate := range cfg.TokendingsClientCertificates {
+		if err := mgr.Add(certificate); err != nil {
+			log.Error("unable to set up client certificate reloading", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// authenticators that keep state, such as a watched token file, run alongside the manager
+	authenticators := make(map[tokendings.Authenticator]bool)
+	for _, instance := range slices.Concat(cfg.TokendingsInstances, cfg.TokendingsDecommissionedInstances) {
+		runnable, ok := instance.Authenticator.(manager.Runnable)
+		if !ok || authenticators[instance.Authenticator] {
+			continue
+		}
+		authenticators[instance.Authenticator] = true
+
+		if err := mgr.Add(runnable); err != nil {
+			log.Error("unable to set up tokendings authenticator", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := mgr.Add(&controllers.MetadataResolver{
+		Instances:      cfg.TokendingsInstances,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+	}); err != nil {
+		log.Error("unable to set up metadata resolver", "error", err)
+		os.Exit(1)
+	}
+
+	if err := mgr.Add(&controllers.MetadataRefresher{
+		Client:    k8sClient,
+		Instances: cfg.TokendingsInstances,
+		Interval:  cfg.TokendingsMetadataRefresh,
+		Recorder:  recorder,
+	}); err != nil {
+		log.Error("unable to set up metadata refresher", "error", err)
+		os.Exit(1)
+	}
+
 	log.Info("starting metrics refresh goroutine")
-	go jwkermetrics.RefreshTotalJwkerClusterMetrics(mgr.GetClient())
+	decommissioned := make([]string, len(cfg.TokendingsDecommissionedInstances))
+	for i, instance := range cfg.TokendingsDecommissionedInstances {
+		decommissioned[i] = instance.BaseURL
+	}
+	go jwkermetrics.RefreshTotalJwkerClusterMetrics(mgr.GetClient(), cfg.ResyncPeriod, decommissioned)
 
 	log.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -4,31 +4,70 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/go-jose/go-jose/v4"
 	"github.com/nais/jwker/pkg/config"
+	"github.com/nais/jwker/pkg/dryrun"
+	"github.com/nais/jwker/pkg/health"
 	"github.com/nais/jwker/pkg/jwk"
 	jwkermetrics "github.com/nais/jwker/pkg/metric"
+	"github.com/nais/jwker/pkg/resync"
 	"github.com/nais/jwker/pkg/secret"
+	"github.com/nais/jwker/pkg/status"
 	"github.com/nais/jwker/pkg/tokendings"
 	jwkerv1 "github.com/nais/liberator/pkg/apis/nais.io/v1"
 	"github.com/nais/liberator/pkg/events"
 	libernetes "github.com/nais/liberator/pkg/kubernetes"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	kevents "k8s.io/client-go/tools/events"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 const (
 	finalizer = "jwker.nais.io/finalizer"
+
+	// unresolvedMetadataRequeue is how long to wait before retrying a Jwker that needs unresolved Tokendings metadata.
+	unresolvedMetadataRequeue = 30 * time.Second
+)
+
+const (
+	EventDecommissioned     = "Decommissioned"
+	EventDriftRepaired      = "DriftRepaired"
+	EventFailedDecommission = "FailedDecommission"
+	EventPrimaryChanged     = "PrimaryChanged"
 )
 
+// Reasons for the conditions on a Jwker; see status.Conditions.
+const (
+	ReasonCleanedUp              = "CleanedUp"
+	ReasonDecommissionFailed     = "DecommissionFailed"
+	ReasonFinalizeFailed         = "FinalizeFailed"
+	ReasonPartiallyRegistered    = "PartiallyRegistered"
+	ReasonPrepareFailed          = "PrepareFailed"
+	ReasonRegistered             = "Registered"
+	ReasonRegistrationFailed     = "RegistrationFailed"
+	ReasonRegistrationIncomplete = "RegistrationIncomplete"
+	ReasonSecretDeletionFailed   = "SecretDeletionFailed"
+	ReasonSecretWritten          = "SecretWritten"
+	ReasonSynchronizationFailed  = "SynchronizationFailed"
+)
+
+// errPartialSynchronization is returned when the secret was written, but registration failed for some instances.
+var errPartialSynchronization = fmt.Errorf("partial synchronization")
+
 // JwkerReconciler reconciles a Jwker object
 type JwkerReconciler struct {
 	client.Client
@@ -36,6 +75,18 @@ type JwkerReconciler struct {
 	Reader   client.Reader
 	Recorder kevents.EventRecorder
 	Config   *config.Config
+	Watchdog *health.Watchdog
+}
+
+// syncResult is the outcome of registering a client with the Tokendings instances.
+type syncResult struct {
+	results tokendings.RegistrationResults
+	// primary is the base URL of the instance whose metadata was written to the secret, if any.
+	primary string
+	// fingerprint identifies the registration that was synchronized; see tokendings.Fingerprint.
+	fingerprint string
+	// secret is the name of the secret that was written, if it was.
+	secret string
 }
 
 type transaction struct {
@@ -50,11 +101,27 @@ func (r *JwkerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		MaxConcurrentReconciles: r.Config.MaxConcurrentReconciles,
 	}
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&jwkerv1.Jwker{}).
+		For(&jwkerv1.Jwker{}, builder.WithPredicates(relevantChanges())).
 		WithOptions(opts).
 		Complete(r)
 }
 
+// relevantChanges filters out updates that only touch status or annotations, most of which are written by jwker itself.
+// Reconciling on those would bypass the backoff for failed reconciles, as well as the resync schedule.
+func relevantChanges() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return true
+			}
+
+			return e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration() ||
+				!e.ObjectNew.GetDeletionTimestamp().IsZero() ||
+				!slices.Equal(e.ObjectOld.GetFinalizers(), e.ObjectNew.GetFinalizers())
+		},
+	}
+}
+
 // +kubebuilder:rbac:groups=nais.io,resources=jwkers,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=nais.io,resources=jwkers/status,verbs=get;update;patch
 
@@ -62,6 +129,7 @@ func (r *JwkerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 	log := ctrl.LoggerFrom(ctx).WithName("reconciler")
 	ctx = ctrl.LoggerInto(ctx, log)
 	defer jwkermetrics.JwkersProcessedCount.Inc()
+	defer r.Watchdog.Track()()
 
 	var jwker jwkerv1.Jwker
 	err := r.Get(ctx, req.NamespacedName, &jwker)
@@ -74,6 +142,9 @@ func (r *JwkerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 	if !jwker.ObjectMeta.DeletionTimestamp.IsZero() {
 		if err := r.finalize(ctx, r.clientID(req), &jwker); err != nil {
 			jwkermetrics.JwkersProcessingFailedCount.Inc()
+			if err := r.updateConditions(ctx, jwker, falseCondition(status.ConditionCleanupComplete, ReasonFinalizeFailed, err)); err != nil {
+				log.Error(err, "failed to update conditions")
+			}
 			return ctrl.Result{}, fmt.Errorf("finalize: %w", err)
 		}
 		return ctrl.Result{}, nil
@@ -86,20 +157,69 @@ func (r *JwkerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		}); err != nil {
 			return ctrl.Result{}, fmt.Errorf("registering finalizer: %w", err)
 		}
-		return ctrl.Result{}, nil
+		// the finalizer is never added in a dry run, so no update would trigger the next reconcile
+		if r.Config.DryRun == nil {
+			return ctrl.Result{}, nil
+		}
+	}
+
+	decommissionErr := r.decommission(ctx, req, jwker)
+	if decommissionErr != nil {
+		log.Error(decommissionErr, "failed to delete client from decommissioned Tokendings instances")
 	}
 
+	resyncing := false
 	if jwker.GetGeneration() == jwker.Status.ObservedGeneration {
-		log.V(4).WithValues(
-			".metadata.generation", jwker.GetGeneration(),
-			".status.observedGeneration", jwker.Status.ObservedGeneration,
-		).Info("generation is unchanged; skipping reconciliation")
-		return ctrl.Result{}, nil
+		due, after := resync.Due(time.Now(), jwker.Status.SynchronizationTimestamp.Time, r.Config.ResyncPeriod, jwker.GetUID())
+
+		missing := r.missingRegistrations(ctx, jwker)
+		if len(missing) > 0 {
+			log.Info("not registered with all configured Tokendings instances; back-filling registrations", "missing", missing)
+			due = true
+		}
+
+		if !due {
+			log.V(4).WithValues(
+				".metadata.generation", jwker.GetGeneration(),
+				".status.observedGeneration", jwker.Status.ObservedGeneration,
+			).Info("generation is unchanged; skipping reconciliation")
+			r.updateDecommissionCondition(ctx, jwker, decommissionErr)
+			if decommissionErr != nil {
+				return ctrl.Result{}, fmt.Errorf("decommission: %w", decommissionErr)
+			}
+			return ctrl.Result{RequeueAfter: after}, nil
+		}
+
+		if len(missing) == 0 {
+			log.Info("resync period has passed; re-registering with Tokendings", "lastSynchronized", jwker.Status.SynchronizationTimestamp)
+		}
+		resyncing = true
+	}
+
+	// the secret needs the metadata of every instance; see tokendings.ResolveMetadata
+	if unresolved := tokendings.Unresolved(r.Config.TokendingsInstances); len(unresolved) > 0 {
+		log.Info("metadata is unresolved for some Tokendings instances; requeueing", "unresolved", unresolved)
+		r.updateDecommissionCondition(ctx, jwker, decommissionErr)
+		if decommissionErr != nil {
+			return ctrl.Result{}, fmt.Errorf("decommission: %w", decommissionErr)
+		}
+		return ctrl.Result{RequeueAfter: unresolvedMetadataRequeue}, nil
+	}
+
+	var synced syncResult
+	synchronized := false
+
+	conditions := make([]metav1.Condition, 0)
+	if decommissionErr != nil {
+		conditions = append(conditions, cleanupCondition(decommissionErr, nil))
 	}
 
 	// update status subresource at the end of reconciliation, regardless of success or failure
 	defer func() {
-		jwker.Status.SynchronizationTimestamp = metav1.Now()
+		// a failed resync keeps the previous timestamp so that it is retried; see resync.Due
+		if !resyncing || synchronized {
+			jwker.Status.SynchronizationTimestamp = metav1.Now()
+		}
 
 		if err := r.updateJwker(ctx, jwker, func(existing *jwkerv1.Jwker) error {
 			existing.Status = jwker.Status
@@ -108,19 +228,47 @@ func (r *JwkerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 			log.Error(err, "failed to update status subresource")
 			return
 		}
+
+		if err := r.updateInstanceStatus(ctx, jwker, synced, conditions); err != nil {
+			log.Error(err, "failed to update instance status")
+		}
 	}()
 
 	tx, err := r.prepare(ctx, req, jwker)
 	if err != nil {
 		jwker.Status.SynchronizationState = events.FailedPrepare
+		conditions = append(conditions, falseCondition(status.ConditionSecretSynced, ReasonPrepareFailed, err))
 		jwkermetrics.JwkersProcessingFailedCount.Inc()
 		return ctrl.Result{}, fmt.Errorf("prepare: %w", err)
 	}
+	r.Config.DryRun.Record(ctx, keyDecision(jwker, tx.jwks))
 
-	err = r.synchronize(*tx, jwker)
+	synced, err = r.synchronize(*tx, jwker, resyncing)
+	conditions = append(conditions, synchronizedConditions(synced, err)...)
 	if err != nil {
 		jwker.Status.SynchronizationState = events.FailedSynchronization
 		jwkermetrics.JwkersProcessingFailedCount.Inc()
+
+		if errors.Is(err, errPartialSynchronization) {
+			// the secret has been written; keep its key for the next attempt
+			jwker.Status.SynchronizationSecretName = jwker.Spec.SecretName
+			jwker.Status.ClientID = r.clientID(req).String()
+			jwker.Status.KeyIDs = tx.jwks.KeyIDs()
+		}
+
+		if tokendings.IsPermanent(err) {
+			// retrying won't help; park the resource until its spec changes
+			log.Error(err, "synchronization failed permanently; will not retry until the Jwker is changed")
+			r.Recorder.Eventf(&jwker, nil, corev1.EventTypeWarning, events.FailedSynchronization, "Synchronize", "Synchronization failed permanently: %s", err)
+			jwker.Status.ObservedGeneration = jwker.GetGeneration()
+			return r.requeueForResync(jwker), nil
+		}
+
+		if retryAfter := tokendings.RetryAfter(err); retryAfter > 0 {
+			log.Error(err, "synchronization failed; retrying as requested by Tokendings", "retryAfter", retryAfter)
+			return ctrl.Result{RequeueAfter: retryAfter}, nil
+		}
+
 		return ctrl.Result{}, fmt.Errorf("synchronize: %w", err)
 	}
 
@@ -130,6 +278,7 @@ func (r *JwkerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 	jwker.Status.ClientID = r.clientID(req).String()
 	jwker.Status.KeyIDs = tx.jwks.KeyIDs()
 
+	deletionErrs := make([]error, 0)
 	for _, oldSecret := range tx.secretLists.Unused.Items {
 		if oldSecret.GetName() == jwker.Spec.SecretName {
 			continue
@@ -139,12 +288,44 @@ func (r *JwkerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		if err := r.Delete(tx.ctx, &oldSecret); err != nil {
 			if !k8serrors.IsNotFound(err) {
 				log.Error(err, fmt.Sprintf("failed to delete unused secret %q", oldSecret.GetName()))
+				deletionErrs = append(deletionErrs, fmt.Errorf("deleting unused secret %q: %w", oldSecret.GetName(), err))
 			}
 		}
 	}
+	conditions = append(conditions, cleanupCondition(decommissionErr, deletionErrs))
+
+	synchronized = true
+	if resyncing {
+		jwkermetrics.JwkersResyncedCount.Inc()
+	}
+
+	if decommissionErr != nil {
+		return ctrl.Result{}, fmt.Errorf("decommission: %w", decommissionErr)
+	}
 
 	log.Info("successfully reconciled")
-	return ctrl.Result{}, nil
+	return r.requeueForResync(jwker), nil
+}
+
+// keyDecision describes whether the client's key set is reused or changed, for dry runs.
+func keyDecision(jwker jwkerv1.Jwker, jwks jwk.KeySet) dryrun.Change {
+	change := dryrun.Change{
+		Kind:   "Key",
+		Name:   client.ObjectKeyFromObject(&jwker).String(),
+		Action: "reuse",
+	}
+	if keyIDs := jwks.KeyIDs(); !slices.Equal(jwker.Status.KeyIDs, keyIDs) {
+		change.Action = "rotate"
+		change.Diff = []dryrun.Difference{{Path: ".status.keyIDs", Old: strings.Join(jwker.Status.KeyIDs, ","), New: strings.Join(keyIDs, ",")}}
+	}
+	return change
+}
+
+// requeueForResync schedules the next periodic resync of a Jwker that has just been synchronized.
+func (r *JwkerReconciler) requeueForResync(jwker jwkerv1.Jwker) ctrl.Result {
+	now := time.Now()
+	_, after := resync.Due(now, now, r.Config.ResyncPeriod, jwker.GetUID())
+	return ctrl.Result{RequeueAfter: after}
 }
 
 func (r *JwkerReconciler) prepare(ctx context.Context, req ctrl.Request, jwker jwkerv1.Jwker) (*transaction, error) {
@@ -202,28 +383,95 @@ func (r *JwkerReconciler) generateNewKeySet(ctx context.Context, req ctrl.Reques
 	}, nil
 }
 
-func (r *JwkerReconciler) synchronize(tx transaction, jwker jwkerv1.Jwker) error {
+// synchronize registers the client with every Tokendings instance and writes the secret. Instances that already accepted
+// an equivalent registration are skipped; see tokendings.Fingerprint. A resync calls every instance, reading the client
+// instead, and only writes to instances where the client has drifted from the Jwker.
+func (r *JwkerReconciler) synchronize(tx transaction, jwker jwkerv1.Jwker, resyncing bool) (syncResult, error) {
 	clientID := r.clientID(tx.req)
 	log := ctrl.LoggerFrom(tx.ctx).WithValues("subsystem", "synchronize")
 
-	registration, err := tokendings.MakeClientRegistration(r.Config.ClientJwk, &tx.jwks.PublicKeys, clientID, jwker)
+	fingerprint, err := tokendings.Fingerprint(clientID, &tx.jwks.PublicKeys, jwker)
 	if err != nil {
-		return fmt.Errorf("create client registration payload: %s", err)
+		return syncResult{}, fmt.Errorf("create client registration payload: %s", err)
+	}
+
+	known, err := status.Instances(&jwker)
+	if err != nil {
+		log.Error(err, "ignoring invalid instance status")
+	}
+
+	states, err := r.registrationStates(tx.ctx, jwker)
+	if err != nil {
+		return syncResult{}, fmt.Errorf("reading registration state: %w", err)
 	}
 
 	instances := r.Config.TokendingsInstances
-	for _, instance := range instances {
-		if err := instance.RegisterClient(registration); err != nil {
-			return fmt.Errorf("registering client with Tokendings %q: %w", instance.BaseURL, err)
+	pending := instances
+	if !resyncing {
+		pending = slices.DeleteFunc(slices.Clone(instances), func(i tokendings.Instance) bool {
+			return status.Unchanged(known, i.BaseURL, fingerprint)
+		})
+	}
+
+	var results tokendings.RegistrationResults
+	if len(pending) > 0 {
+		registration, err := tokendings.MakeClientRegistration(r.Config.ClientKeys.Active(), &tx.jwks.PublicKeys, clientID, jwker)
+		if err != nil {
+			return syncResult{}, fmt.Errorf("create client registration payload: %s", err)
+		}
+
+		if resyncing {
+			results = tokendings.SyncAll(tx.ctx, pending, clientID, registration, states, r.Config.TokendingsParallelism)
+		} else {
+			results = tokendings.RegisterAll(tx.ctx, pending, registration, states, r.Config.TokendingsParallelism)
+		}
+	}
+	results = withSkipped(instances, results)
+
+	for _, result := range results {
+		if result.Skipped {
+			log.V(1).Info(fmt.Sprintf("%q is registered unchanged with Tokendings at %q; skipping", clientID.String(), result.BaseURL))
+			jwkermetrics.TokendingsRegistrationsSkippedCount.WithLabelValues(result.BaseURL).Inc()
+			continue
+		}
+		if len(result.Drift) > 0 {
+			log.Info(fmt.Sprintf("%q has drifted in Tokendings at %q", clientID.String(), result.BaseURL), "drift", result.Drift)
+			if result.Err == nil {
+				r.Recorder.Eventf(&jwker, nil, corev1.EventTypeNormal, EventDriftRepaired, "Synchronize", "Repaired client in Tokendings instance %q: %s", result.BaseURL, strings.Join(result.Drift, "; "))
+				jwkermetrics.TokendingsDriftRepairedCount.WithLabelValues(result.BaseURL).Inc()
+			}
+		}
+		if result.Err != nil {
+			log.Error(result.Err, fmt.Sprintf("failed to register %q with Tokendings at %q", clientID.String(), result.BaseURL), "duration", result.Duration)
+			continue
 		}
-		log.Info(fmt.Sprintf("registered %q with Tokendings at %q", clientID.String(), instance.BaseURL))
+		log.Info(fmt.Sprintf("registered %q with Tokendings at %q", clientID.String(), result.BaseURL), "duration", result.Duration)
+	}
+
+	synced := syncResult{results: results, fingerprint: fingerprint}
+
+	// the registration access tokens are needed to manage the clients later, regardless of whether the secret is written
+	if err := r.writeRegistrationStates(tx.ctx, jwker, clientID, states, results); err != nil {
+		return synced, fmt.Errorf("writing registration state: %w", err)
+	}
+
+	currentPrimary := status.Primary(known)
+
+	primary, err := r.Config.TokendingsPrimary.Select(instances, jwker.GetNamespace(), currentPrimary, results)
+	if err != nil {
+		return synced, fmt.Errorf("selecting primary Tokendings instance: %w", errors.Join(err, results.Err()))
+	}
+
+	policy := r.Config.TokendingsRegistrationPolicy
+	if !policy.Satisfied(results, primary.BaseURL) {
+		return synced, fmt.Errorf("registration policy %q not satisfied: %w", policy, results.Err())
 	}
 
 	secretName := jwker.Spec.SecretName
-	secretData := secret.Data{ClientID: clientID, Jwk: tx.jwks.PrivateKey, Tokendings: instances[0]}
+	secretData := secret.Data{ClientID: clientID, Jwk: tx.jwks.PrivateKey, Tokendings: primary, Instances: instances, Registrations: states}
 	secretSpec, err := secret.CreateSecretSpec(secretName, secretData)
 	if err != nil {
-		return fmt.Errorf("creating secret spec: %w", err)
+		return synced, fmt.Errorf("creating secret spec: %w", err)
 	}
 
 	target := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
@@ -238,13 +486,362 @@ func (r *JwkerReconciler) synchronize(tx transaction, jwker jwkerv1.Jwker) error
 		return ctrl.SetControllerReference(&jwker, target, r.Scheme)
 	})
 	if err != nil {
-		return fmt.Errorf("creating or updating secret %s: %w", secretName, err)
+		return synced, fmt.Errorf("creating or updating secret %s: %w", secretName, err)
 	}
 
 	log.Info(fmt.Sprintf("secret %q %s", secretName, res))
+
+	if currentPrimary != "" && currentPrimary != primary.BaseURL {
+		log.Info(fmt.Sprintf("switched primary Tokendings from %q to %q", currentPrimary, primary.BaseURL))
+		r.Recorder.Eventf(&jwker, nil, corev1.EventTypeNormal, EventPrimaryChanged, "Synchronize", "Switched primary Tokendings instance in secret %q from %q to %q", secretName, currentPrimary, primary.BaseURL)
+		jwkermetrics.TokendingsPrimaryChangedCount.WithLabelValues(currentPrimary, primary.BaseURL).Inc()
+	}
+
+	synced.primary = primary.BaseURL
+	synced.secret = secretName
+	if err := results.Err(); err != nil {
+		return synced, fmt.Errorf("%w: %w", errPartialSynchronization, err)
+	}
+	return synced, nil
+}
+
+// withSkipped returns a result for every instance in order, marking the instances without one in results as skipped.
+func withSkipped(instances []tokendings.Instance, results tokendings.RegistrationResults) tokendings.RegistrationResults {
+	all := make(tokendings.RegistrationResults, len(instances))
+	for i, instance := range instances {
+		j := slices.IndexFunc(results, func(r tokendings.RegistrationResult) bool { return r.BaseURL == instance.BaseURL })
+		if j < 0 {
+			all[i] = tokendings.RegistrationResult{BaseURL: instance.BaseURL, Skipped: true}
+			continue
+		}
+		all[i] = results[j]
+	}
+	return all
+}
+
+// registrationStates returns the state from the client's latest registration with each instance that assigned one, keyed by base URL.
+// Client IDs and configuration URIs are recorded in status, while registration access tokens are kept in a secret.
+func (r *JwkerReconciler) registrationStates(ctx context.Context, jwker jwkerv1.Jwker) (map[string]tokendings.RegistrationState, error) {
+	states := make(map[string]tokendings.RegistrationState)
+
+	known, err := status.Instances(&jwker)
+	if err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "ignoring invalid instance status; assuming no registration state")
+		return states, nil
+	}
+	for _, instance := range known {
+		if instance.Registration == nil || instance.Registration.ClientID == "" {
+			continue
+		}
+		states[instance.BaseURL] = tokendings.RegistrationState{
+			ClientID:              instance.Registration.ClientID,
+			RegistrationClientURI: instance.Registration.RegistrationClientURI,
+		}
+	}
+	if len(states) == 0 {
+		return states, nil
+	}
+
+	var sec corev1.Secret
+	key := client.ObjectKey{Namespace: jwker.GetNamespace(), Name: secret.RegistrationSecretName(jwker.GetName())}
+	if err := r.Reader.Get(ctx, key, &sec); err != nil {
+		if k8serrors.IsNotFound(err) {
+			// without their tokens, the clients cannot be managed; they are registered anew
+			return states, nil
+		}
+		return nil, fmt.Errorf("getting secret %q: %w", key.Name, err)
+	}
+
+	tokens, err := secret.ExtractRegistrationAccessTokens(sec)
+	if err != nil {
+		return nil, err
+	}
+	for baseURL, state := range states {
+		state.RegistrationAccessToken = tokens[baseURL]
+		states[baseURL] = state
+	}
+	return states, nil
+}
+
+// writeRegistrationStates merges the state assigned by the latest registrations into states, and writes the registration
+// access tokens to the Jwker's registration secret. Nothing is written unless some instance has assigned state.
+func (r *JwkerReconciler) writeRegistrationStates(ctx context.Context, jwker jwkerv1.Jwker, clientID tokendings.ClientID, states map[string]tokendings.RegistrationState, results tokendings.RegistrationResults) error {
+	changed := false
+	for _, result := range results {
+		state := result.Response.State()
+		if state == nil || states[result.BaseURL] == *state {
+			continue
+		}
+		states[result.BaseURL] = *state
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	tokens := make(map[string]string, len(states))
+	for baseURL, state := range states {
+		tokens[baseURL] = state.RegistrationAccessToken
+	}
+
+	spec, err := secret.CreateRegistrationSecretSpec(clientID, tokens)
+	if err != nil {
+		return err
+	}
+
+	target := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      spec.GetName(),
+		Namespace: spec.GetNamespace(),
+	}}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, target, func() error {
+		target.SetLabels(spec.GetLabels())
+		target.StringData = spec.StringData
+		return ctrl.SetControllerReference(&jwker, target, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("creating or updating secret %s: %w", spec.GetName(), err)
+	}
 	return nil
 }
 
+// registrationStatus returns the values from a registration response that are recorded in status, or nil if there was no response.
+func registrationStatus(response *tokendings.ClientRegistrationResponse) *status.Registration {
+	if response == nil {
+		return nil
+	}
+
+	keyIDs := make([]string, len(response.Jwks.Keys))
+	for i, key := range response.Jwks.Keys {
+		keyIDs[i] = key.KeyID
+	}
+	return &status.Registration{
+		ClientName:              response.ClientName,
+		KeyIDs:                  keyIDs,
+		GrantTypes:              response.GrantTypes,
+		TokenEndpointAuthMethod: response.TokenEndpointAuthMethod,
+		ClientID:                response.ClientID,
+		RegistrationClientURI:   response.RegistrationClientURI,
+	}
+}
+
+// registrationState returns the state for the instance at baseURL, or nil if there is none.
+func registrationState(states map[string]tokendings.RegistrationState, baseURL string) *tokendings.RegistrationState {
+	state, ok := states[baseURL]
+	if !ok {
+		return nil
+	}
+	return &state
+}
+
+// updateInstanceStatus records the outcome of the latest registration with each Tokendings instance, the primary instance, if any,
+// and the given conditions.
+func (r *JwkerReconciler) updateInstanceStatus(ctx context.Context, jwker jwkerv1.Jwker, synced syncResult, conditions []metav1.Condition) error {
+	if len(synced.results) == 0 && len(conditions) == 0 {
+		return nil
+	}
+
+	now := metav1.Now()
+	updated := make([]status.Instance, 0, len(synced.results))
+	for _, result := range synced.results {
+		// skipped instances keep their previous entry
+		if result.Skipped {
+			continue
+		}
+		instance := status.Instance{
+			BaseURL:            result.BaseURL,
+			Registered:         result.Err == nil,
+			LastTransitionTime: now,
+			Registration:       registrationStatus(result.Response),
+			Drift:              result.Drift,
+		}
+		if result.Err != nil {
+			instance.Error = result.Err.Error()
+		} else {
+			instance.Fingerprint = synced.fingerprint
+		}
+		updated = append(updated, instance)
+	}
+
+	return r.updateJwker(ctx, jwker, func(existing *jwkerv1.Jwker) error {
+		conditionsChanged, err := setConditions(ctx, existing, conditions...)
+		if err != nil {
+			return err
+		}
+		if len(synced.results) == 0 {
+			if !conditionsChanged {
+				return nil
+			}
+			return r.Update(ctx, existing)
+		}
+
+		instances, err := status.Instances(existing)
+		if err != nil {
+			// the annotation is rewritten from scratch
+			ctrl.LoggerFrom(ctx).Error(err, "ignoring invalid instance status")
+		}
+
+		// an instance that did not respond keeps its previous registration, which is needed to manage the client later
+		for i := range updated {
+			if updated[i].Registration != nil {
+				continue
+			}
+			if j := slices.IndexFunc(instances, func(e status.Instance) bool { return e.BaseURL == updated[i].BaseURL }); j >= 0 {
+				updated[i].Registration = instances[j].Registration
+			}
+		}
+
+		merged := status.MergeInstances(instances, updated)
+		if synced.primary != "" {
+			status.SetPrimary(merged, synced.primary)
+		}
+
+		changed, err := status.SetInstances(existing, merged)
+		if err != nil || !(changed || conditionsChanged) {
+			return err
+		}
+		return r.Update(ctx, existing)
+	})
+}
+
+// updateConditions records the given conditions on the Jwker, along with Ready.
+func (r *JwkerReconciler) updateConditions(ctx context.Context, jwker jwkerv1.Jwker, conditions ...metav1.Condition) error {
+	return r.updateJwker(ctx, jwker, func(existing *jwkerv1.Jwker) error {
+		changed, err := setConditions(ctx, existing, conditions...)
+		if err != nil || !changed {
+			return err
+		}
+		return r.Update(ctx, existing)
+	})
+}
+
+// updateDecommissionCondition records the outcome of decommissioning for a Jwker that is not synchronized,
+// which would otherwise record it along with its other conditions.
+func (r *JwkerReconciler) updateDecommissionCondition(ctx context.Context, jwker jwkerv1.Jwker, decommissionErr error) {
+	if len(r.Config.TokendingsDecommissionedInstances) == 0 {
+		return
+	}
+
+	existing, err := status.Conditions(&jwker)
+	if err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "ignoring invalid conditions")
+	}
+	// a successful decommission says nothing about unused secrets, which are only deleted when the Jwker is synchronized
+	cleanup := meta.FindStatusCondition(existing, status.ConditionCleanupComplete)
+	if decommissionErr == nil && (cleanup == nil || cleanup.Reason != ReasonDecommissionFailed) {
+		return
+	}
+
+	if err := r.updateConditions(ctx, jwker, cleanupCondition(decommissionErr, nil)); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to update conditions")
+	}
+}
+
+// setConditions merges the given conditions into those recorded on the Jwker. It reports whether they changed.
+func setConditions(ctx context.Context, jwker *jwkerv1.Jwker, conditions ...metav1.Condition) (bool, error) {
+	if len(conditions) == 0 {
+		return false, nil
+	}
+
+	existing, err := status.Conditions(jwker)
+	if err != nil {
+		// the annotation is rewritten from scratch
+		ctrl.LoggerFrom(ctx).Error(err, "ignoring invalid conditions")
+	}
+	return status.SetConditions(jwker, status.MergeConditions(existing, jwker.GetGeneration(), conditions...))
+}
+
+// synchronizedConditions describes the outcome of synchronize: the registration with each Tokendings instance, if it was attempted,
+// and the secret. The messages carry the errors from Tokendings, so that users can tell what to fix.
+func synchronizedConditions(synced syncResult, err error) []metav1.Condition {
+	conditions := make([]metav1.Condition, 0, 2)
+
+	if len(synced.results) > 0 {
+		failed := synced.results.Failed()
+		registered := metav1.Condition{
+			Type:    status.ConditionRegistered,
+			Status:  metav1.ConditionTrue,
+			Reason:  ReasonRegistered,
+			Message: fmt.Sprintf("registered with %d Tokendings instances", len(synced.results)),
+		}
+		if len(failed) > 0 {
+			messages := make([]string, len(failed))
+			for i, result := range failed {
+				messages[i] = fmt.Sprintf("%s: %s", result.BaseURL, result.Err)
+			}
+
+			registered.Status = metav1.ConditionFalse
+			registered.Reason = ReasonPartiallyRegistered
+			if len(failed) == len(synced.results) {
+				registered.Reason = ReasonRegistrationFailed
+			}
+			registered.Message = conditionMessage(fmt.Sprintf("failed to register with %d of %d Tokendings instances: %s", len(failed), len(synced.results), strings.Join(messages, "; ")))
+		}
+		conditions = append(conditions, registered)
+	}
+
+	switch {
+	case synced.secret != "":
+		conditions = append(conditions, metav1.Condition{
+			Type:    status.ConditionSecretSynced,
+			Status:  metav1.ConditionTrue,
+			Reason:  ReasonSecretWritten,
+			Message: fmt.Sprintf("secret %q holds the current keys and the metadata of Tokendings at %q", synced.secret, synced.primary),
+		})
+	case synced.results.Err() != nil:
+		conditions = append(conditions, falseCondition(status.ConditionSecretSynced, ReasonRegistrationIncomplete, err))
+	case err != nil:
+		conditions = append(conditions, falseCondition(status.ConditionSecretSynced, ReasonSynchronizationFailed, err))
+	}
+	return conditions
+}
+
+// cleanupCondition describes the outcome of deleting the client from decommissioned Tokendings instances, and of deleting unused secrets.
+func cleanupCondition(decommissionErr error, deletionErrs []error) metav1.Condition {
+	switch {
+	case decommissionErr != nil:
+		return falseCondition(status.ConditionCleanupComplete, ReasonDecommissionFailed, decommissionErr)
+	case len(deletionErrs) > 0:
+		return falseCondition(status.ConditionCleanupComplete, ReasonSecretDeletionFailed, errors.Join(deletionErrs...))
+	default:
+		return metav1.Condition{
+			Type:    status.ConditionCleanupComplete,
+			Status:  metav1.ConditionTrue,
+			Reason:  ReasonCleanedUp,
+			Message: "unused secrets and clients in decommissioned Tokendings instances have been deleted",
+		}
+	}
+}
+
+func falseCondition(conditionType, reason string, err error) metav1.Condition {
+	return metav1.Condition{
+		Type:    conditionType,
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: conditionMessage(err.Error()),
+	}
+}
+
+// conditionMessage returns the message on a single line, as joined errors are separated by newlines.
+func conditionMessage(message string) string {
+	return strings.ReplaceAll(message, "\n", "; ")
+}
+
+// missingRegistrations returns the base URLs of the configured Tokendings instances that the Jwker is not known to be registered with.
+func (r *JwkerReconciler) missingRegistrations(ctx context.Context, jwker jwkerv1.Jwker) []string {
+	baseURLs := make([]string, len(r.Config.TokendingsInstances))
+	for i, instance := range r.Config.TokendingsInstances {
+		baseURLs[i] = instance.BaseURL
+	}
+
+	instances, err := status.Instances(&jwker)
+	if err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "ignoring invalid instance status; assuming no registrations")
+		return baseURLs
+	}
+
+	return status.MissingRegistrations(instances, baseURLs)
+}
+
 // finalize purges relevant resources from external systems (i.e. the tokendings instances)
 func (r *JwkerReconciler) finalize(ctx context.Context, clientId tokendings.ClientID, jwker *jwkerv1.Jwker) error {
 	if !controllerutil.ContainsFinalizer(jwker, finalizer) {
@@ -253,8 +850,29 @@ func (r *JwkerReconciler) finalize(ctx context.Context, clientId tokendings.Clie
 
 	log := ctrl.LoggerFrom(ctx).WithValues("subsystem", "finalize")
 
-	for _, instance := range r.Config.TokendingsInstances {
-		if err := instance.DeleteClient(ctx, clientId); err != nil {
+	known, err := status.Instances(jwker)
+	if err != nil {
+		log.Error(err, "ignoring invalid instance status")
+	}
+
+	instances := slices.Clone(r.Config.TokendingsInstances)
+	for _, instance := range r.Config.TokendingsDecommissionedInstances {
+		if !status.Decommissioned(known, instance.BaseURL) {
+			instances = append(instances, instance)
+		}
+	}
+
+	states, err := r.registrationStates(ctx, *jwker)
+	if err != nil {
+		return fmt.Errorf("reading registration state: %w", err)
+	}
+
+	for _, instance := range instances {
+		if err := instance.DeleteClient(ctx, clientId, registrationState(states, instance.BaseURL)); err != nil {
+			if errors.Is(err, tokendings.ErrNotFound) {
+				log.Info(fmt.Sprintf("%q not found in Tokendings at %q; assuming already deleted", clientId.String(), instance.BaseURL))
+				continue
+			}
 			return fmt.Errorf("deleting client from Tokendings at %q: %w", instance.BaseURL, err)
 		}
 		log.Info(fmt.Sprintf("deleted %q from Tokendings at %q", clientId.String(), instance.BaseURL))
@@ -269,6 +887,68 @@ func (r *JwkerReconciler) finalize(ctx context.Context, clientId tokendings.Clie
 	return nil
 }
 
+// decommission deletes the client from the decommissioned Tokendings instances that it has not been deleted from yet.
+// A Jwker without an entry for a decommissioned instance may have been registered there before jwker recorded per-instance status,
+// so the client is deleted from every decommissioned instance that the Jwker is not recorded as decommissioned from.
+func (r *JwkerReconciler) decommission(ctx context.Context, req ctrl.Request, jwker jwkerv1.Jwker) error {
+	if len(r.Config.TokendingsDecommissionedInstances) == 0 {
+		return nil
+	}
+
+	log := ctrl.LoggerFrom(ctx).WithValues("subsystem", "decommission")
+	clientID := r.clientID(req)
+
+	known, err := status.Instances(&jwker)
+	if err != nil {
+		return fmt.Errorf("reading instance status: %w", err)
+	}
+
+	states, err := r.registrationStates(ctx, jwker)
+	if err != nil {
+		return fmt.Errorf("reading registration state: %w", err)
+	}
+
+	deleted := make([]string, 0)
+	errs := make([]error, 0)
+	for _, instance := range r.Config.TokendingsDecommissionedInstances {
+		if status.Decommissioned(known, instance.BaseURL) {
+			continue
+		}
+
+		err := instance.DeleteClient(ctx, clientID, registrationState(states, instance.BaseURL))
+		switch {
+		case errors.Is(err, tokendings.ErrNotFound):
+			log.V(1).Info(fmt.Sprintf("%q not found in decommissioned Tokendings at %q; assuming already deleted", clientID.String(), instance.BaseURL))
+		case err != nil:
+			r.Recorder.Eventf(&jwker, nil, corev1.EventTypeWarning, EventFailedDecommission, "Decommission", "Failed to delete client from decommissioned Tokendings instance %q: %s", instance.BaseURL, err)
+			errs = append(errs, fmt.Errorf("deleting client from decommissioned Tokendings at %q: %w", instance.BaseURL, err))
+			continue
+		default:
+			log.Info(fmt.Sprintf("deleted %q from decommissioned Tokendings at %q", clientID.String(), instance.BaseURL))
+			r.Recorder.Eventf(&jwker, nil, corev1.EventTypeNormal, EventDecommissioned, "Decommission", "Deleted client from decommissioned Tokendings instance %q", instance.BaseURL)
+			jwkermetrics.TokendingsDecommissionedCount.WithLabelValues(instance.BaseURL).Inc()
+		}
+		deleted = append(deleted, instance.BaseURL)
+	}
+
+	if len(deleted) > 0 {
+		if err := r.updateJwker(ctx, jwker, func(existing *jwkerv1.Jwker) error {
+			instances, err := status.Instances(existing)
+			if err != nil {
+				return err
+			}
+			if _, err := status.SetInstances(existing, status.SetDecommissioned(instances, deleted)); err != nil {
+				return err
+			}
+			return r.Update(ctx, existing)
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("updating instance status: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
 func (r *JwkerReconciler) updateJwker(ctx context.Context, jwker jwkerv1.Jwker, updateFunc func(existing *jwkerv1.Jwker) error) error {
 	existing := &jwkerv1.Jwker{}
 	err := r.Get(ctx, client.ObjectKey{Namespace: jwker.GetNamespace(), Name: jwker.GetName()}, existing)
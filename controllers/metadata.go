@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	jwkermetrics "github.com/nais/jwker/pkg/metric"
+	"github.com/nais/jwker/pkg/secret"
+	"github.com/nais/jwker/pkg/tokendings"
+	"github.com/nais/liberator/pkg/oauth"
+	corev1 "k8s.io/api/core/v1"
+	kevents "k8s.io/client-go/tools/events"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const EventMetadataUpdated = "MetadataUpdated"
+
+// MetadataResolver resolves the authorization server metadata of instances that were unreachable at startup.
+type MetadataResolver struct {
+	Instances      []tokendings.Instance
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Start resolves metadata until every instance is resolved or ctx is done. It implements manager.Runnable.
+func (m *MetadataResolver) Start(ctx context.Context) error {
+	log := ctrl.LoggerFrom(ctx).WithValues("subsystem", "metadata")
+
+	for _, instance := range m.Instances {
+		jwkermetrics.TokendingsMetadataResolved.WithLabelValues(instance.BaseURL).Set(resolved(instance))
+	}
+	if len(tokendings.Unresolved(m.Instances)) == 0 {
+		return nil
+	}
+
+	err := tokendings.ResolveMetadata(ctx, m.Instances, m.InitialBackoff, m.MaxBackoff, func(instance tokendings.Instance, err error) {
+		log.Error(err, fmt.Sprintf("failed to resolve authorization server metadata for Tokendings at %q; retrying", instance.BaseURL))
+	})
+	for _, instance := range m.Instances {
+		jwkermetrics.TokendingsMetadataResolved.WithLabelValues(instance.BaseURL).Set(resolved(instance))
+	}
+	if err != nil {
+		// ctx is done, i.e. jwker is shutting down
+		return nil
+	}
+
+	log.Info("resolved authorization server metadata for all Tokendings instances")
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every replica needs metadata to become ready.
+func (m *MetadataResolver) NeedLeaderElection() bool {
+	return false
+}
+
+func resolved(instance tokendings.Instance) float64 {
+	if instance.Metadata.Resolved() {
+		return 1
+	}
+	return 0
+}
+
+// MetadataRefresher periodically refreshes the authorization server metadata of every Tokendings instance.
+// When the metadata of an instance changes, the secrets that refer to the instance are updated in place;
+// keys and registrations are left untouched.
+type MetadataRefresher struct {
+	Client    client.Client
+	Instances []tokendings.Instance
+	Interval  time.Duration
+	Recorder  kevents.EventRecorder
+}
+
+// Start runs the refresher until ctx is done. It implements manager.Runnable, and only runs on the leader.
+func (m *MetadataRefresher) Start(ctx context.Context) error {
+	if m.Interval <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			m.refresh(ctx)
+		}
+	}
+}
+
+func (m *MetadataRefresher) refresh(ctx context.Context) {
+	log := ctrl.LoggerFrom(ctx).WithValues("subsystem", "metadata")
+
+	for _, instance := range m.Instances {
+		changed, err := instance.Metadata.Refresh(ctx, func(previous, current *oauth.MetadataOAuth) error {
+			log.Info(fmt.Sprintf("authorization server metadata for Tokendings at %q has changed", instance.BaseURL),
+				"issuer", current.Issuer, "jwksURI", current.JwksURI, "tokenEndpoint", current.TokenEndpoint)
+			return m.updateSecrets(ctx, instance.BaseURL, previous, current)
+		})
+		if changed {
+			jwkermetrics.TokendingsMetadataChangedCount.WithLabelValues(instance.BaseURL).Inc()
+		}
+		if err != nil {
+			log.Error(err, fmt.Sprintf("failed to refresh authorization server metadata for Tokendings at %q", instance.BaseURL))
+		}
+	}
+}
+
+// updateSecrets replaces previous with current in every jwker secret that refers to it.
+func (m *MetadataRefresher) updateSecrets(ctx context.Context, baseURL string, previous, current *oauth.MetadataOAuth) error {
+	if previous == nil {
+		return nil
+	}
+
+	var secrets corev1.SecretList
+	if err := m.Client.List(ctx, &secrets, client.MatchingLabels{secret.TokenXSecretLabelKey: secret.TokenXSecretLabelType}); err != nil {
+		return fmt.Errorf("listing secrets: %w", err)
+	}
+
+	log := ctrl.LoggerFrom(ctx).WithValues("subsystem", "metadata")
+	failed := 0
+	for _, sec := range secrets.Items {
+		changed, err := secret.UpdateMetadata(&sec, previous, current)
+		if err != nil {
+			log.Error(err, fmt.Sprintf("failed to update metadata in secret %s/%s", sec.Namespace, sec.Name))
+			failed++
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		if err := m.Client.Update(ctx, &sec); err != nil {
+			log.Error(err, fmt.Sprintf("failed to update secret %s/%s", sec.Namespace, sec.Name))
+			failed++
+			continue
+		}
+
+		jwkermetrics.TokendingsMetadataSecretsUpdatedCount.WithLabelValues(baseURL).Inc()
+		m.Recorder.Eventf(&sec, nil, corev1.EventTypeNormal, EventMetadataUpdated, "RefreshMetadata", "Updated authorization server metadata for Tokendings at %q", baseURL)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to update %d of %d secrets", failed, len(secrets.Items))
+	}
+	return nil
+}
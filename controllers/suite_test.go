@@ -6,13 +6,12 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
-	"net/http"
-	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/go-jose/go-jose/v4"
 	naisiov1 "github.com/nais/liberator/pkg/apis/nais.io/v1"
 	"github.com/nais/liberator/pkg/crd"
 	"github.com/nais/liberator/pkg/events"
@@ -34,7 +33,9 @@ import (
 	"github.com/nais/jwker/pkg/config"
 	"github.com/nais/jwker/pkg/jwk"
 	"github.com/nais/jwker/pkg/secret"
+	"github.com/nais/jwker/pkg/status"
 	"github.com/nais/jwker/pkg/tokendings"
+	"github.com/nais/jwker/pkg/tokendings/tokendingstest"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -53,31 +54,6 @@ const (
 	namespace          = "default"
 )
 
-type tokendingsHandler struct{}
-
-func (h *tokendingsHandler) serveRegistration(w http.ResponseWriter, r *http.Request) {
-	statement := &tokendings.ClientRegistration{}
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(statement)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	w.WriteHeader(http.StatusCreated)
-}
-
-func (h *tokendingsHandler) serveDelete(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNoContent)
-}
-
-func (h *tokendingsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost {
-		h.serveRegistration(w, r)
-	} else if r.Method == http.MethodDelete {
-		h.serveDelete(w, r)
-	}
-}
-
 func fixtures(cli client.Client, tokendingsURL string) error {
 	var err error
 
@@ -244,8 +220,15 @@ func TestReconciler(t *testing.T) {
 	cli = mgr.GetClient()
 	require.NoError(t, err)
 
-	tokendings := httptest.NewServer(&tokendingsHandler{})
-	cfg, err := makeConfig(tokendings.URL)
+	tokendings := tokendingstest.NewServer()
+	defer tokendings.Close()
+
+	// the Jwker fixture has no per-instance status, as if it was registered with this instance before jwker recorded it
+	decommissioned := tokendingstest.NewServer()
+	defer decommissioned.Close()
+	decommissioned.SetRegistration(registeredClient("local:default:app1"))
+
+	cfg, err := makeConfig(tokendings.URL, decommissioned.URL)
 	if err != nil {
 		log.Fatalf("unable to create tokendings instances: %+v", err)
 	}
@@ -308,6 +291,13 @@ func TestReconciler(t *testing.T) {
 		"jwker.nais.io/finalizer",
 	}, jwker.GetFinalizers())
 
+	// the client should be deleted from the decommissioned instance, and not again
+	_, registered := decommissioned.Registration("local:default:app1")
+	assert.False(t, registered, "client should be deleted from decommissioned instance")
+	instances, err := status.Instances(jwker)
+	require.NoError(t, err)
+	assert.True(t, status.Decommissioned(instances, decommissioned.URL))
+
 	// update secret name in jwker spec and verify that a new secret is created
 	err = cli.Get(ctx, key, jwker)
 	require.NoError(t, err)
@@ -350,6 +340,12 @@ func ensureSecretIsValid(t *testing.T, sec *corev1.Secret, tokendingsURL string)
 	assert.Equal(t, tokendingsURL, string(sec.Data[secret.TokenXIssuerKey]))
 	assert.Equal(t, fmt.Sprintf("%s/jwks", tokendingsURL), string(sec.Data[secret.TokenXJwksURIKey]))
 	assert.Equal(t, fmt.Sprintf("%s/token", tokendingsURL), string(sec.Data[secret.TokenXTokenEndpointKey]))
+
+	var instances []secret.InstanceMetadata
+	assert.NoError(t, json.Unmarshal(sec.Data[secret.TokenXInstancesKey], &instances))
+	assert.Len(t, instances, 1)
+	assert.Equal(t, tokendingsURL, instances[0].Issuer)
+	assert.True(t, instances[0].Primary)
 }
 
 func getSecret(ctx context.Context, cli client.Client, namespace, name string) (*corev1.Secret, error) {
@@ -411,17 +407,19 @@ func waitForDeletedJwker(ctx context.Context, cli client.Client, namespace, name
 	}
 }
 
-func makeConfig(tokendingsURL string) (*config.Config, error) {
-	jwk, err := jwk.Generate()
+func makeConfig(tokendingsURL, decommissionedURL string) (*config.Config, error) {
+	key, err := jwk.Generate()
 	if err != nil {
 		return nil, err
 	}
 
-	raw, err := tokendings.ClientAssertion(&jwk, "client1", "http://endpoint/registration/client")
+	raw, err := tokendings.ClientAssertion(&key, "client1", "http://endpoint/registration/client")
 	if err != nil {
 		return nil, err
 	}
 
+	keys := jwk.NewSigningKeyStore(&jwk.SigningKeys{Active: key, Keys: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{key}}})
+
 	authTokenPath := os.TempDir() + "/auth-token"
 	err = os.WriteFile(authTokenPath, []byte(raw), 0o600)
 	if err != nil {
@@ -429,20 +427,34 @@ func makeConfig(tokendingsURL string) (*config.Config, error) {
 	}
 
 	return &config.Config{
-		ClientID:      "jwker",
-		ClientJwk:     &jwk,
-		ClusterName:   "local",
-		AuthTokenPath: authTokenPath,
+		ClientID:                     "jwker",
+		ClientKeys:                   keys,
+		ClusterName:                  "local",
+		AuthTokenPath:                authTokenPath,
+		TokendingsRegistrationPolicy: tokendings.RegistrationPolicyAll,
 		TokendingsInstances: []tokendings.Instance{
-			tokendings.NewInstance(tokendingsURL, "jwker", &jwk, &oauth.MetadataOAuth{
+			tokendings.NewInstance(tokendingsURL, "jwker", keys, &oauth.MetadataOAuth{
 				Issuer:        tokendingsURL,
 				JwksURI:       tokendingsURL + "/jwks",
 				TokenEndpoint: tokendingsURL + "/token",
-			}, authTokenPath),
+			}, authTokenPath, nil),
+		},
+		TokendingsDecommissionedInstances: []tokendings.Instance{
+			tokendings.NewInstance(decommissionedURL, "jwker", keys, &oauth.MetadataOAuth{
+				Issuer:        decommissionedURL,
+				JwksURI:       decommissionedURL + "/jwks",
+				TokenEndpoint: decommissionedURL + "/token",
+			}, authTokenPath, nil),
 		},
 	}, nil
 }
 
+func registeredClient(name string) tokendings.ClientRegistrationResponse {
+	return tokendings.ClientRegistrationResponse{
+		ClientRegistration: tokendings.ClientRegistration{ClientName: name},
+	}
+}
+
 func containsOwnerRef(refs []metav1.OwnerReference, owner *naisiov1.Jwker) bool {
 	expected := metav1.OwnerReference{
 		APIVersion: owner.APIVersion,
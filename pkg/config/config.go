@@ -4,59 +4,159 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/go-jose/go-jose/v4"
 	"github.com/nais/liberator/pkg/oauth"
 
+	"github.com/nais/jwker/pkg/dryrun"
 	"github.com/nais/jwker/pkg/jwk"
+	jwkermetrics "github.com/nais/jwker/pkg/metric"
 	"github.com/nais/jwker/pkg/tokendings"
 )
 
 type Config struct {
-	AuthTokenPath           string
-	ClientID                string
-	ClientJwk               *jose.JSONWebKey
-	ClusterName             string
-	ProbeAddr               string
-	LeaderElection          bool
-	LogLevel                string
-	MaxConcurrentReconciles int
-	MetricsAddr             string
-	TokendingsInstances     []tokendings.Instance
+	AuthTokenPath                     string
+	ClientAssertionLifetime           time.Duration
+	ClientAssertionReplayWindow       time.Duration
+	ClientID                          string
+	ClientKeys                        *jwk.SigningKeyStore
+	ClusterName                       string
+	DryRun                            *dryrun.Recorder
+	ProbeAddr                         string
+	LeaderElection                    bool
+	LivenessReconcileTimeout          time.Duration
+	LogLevel                          string
+	MaxConcurrentReconciles           int
+	MetricsAddr                       string
+	ResyncPeriod                      time.Duration
+	TokendingsCircuitBreaker          tokendings.CircuitBreakerOptions
+	TokendingsClientCertificates      []*tokendings.ClientCertificate
+	TokendingsDecommissionedInstances []tokendings.Instance
+	TokendingsHTTP                    tokendings.HTTPOptions
+	TokendingsInstances               []tokendings.Instance
+	TokendingsMetadataRefresh         time.Duration
+	TokendingsParallelism             int
+	TokendingsPrimary                 tokendings.PrimarySelector
+	TokendingsProbeInterval           time.Duration
+	TokendingsReadinessPolicy         tokendings.ReadinessPolicy
+	TokendingsRegistrationPolicy      tokendings.RegistrationPolicy
+	TokendingsRetry                   tokendings.RetryOptions
 }
 
 func New(ctx context.Context) (*Config, error) {
 	cfg := &Config{}
+	var authenticatorsString string
+	var backendsString string
+	var clientActiveKeyID string
+	var clientJwkFile string
 	var clientJwkJson string
+	var decommissionedString string
+	var dryRun bool
 	var instanceString string
+	var primaryNamespaces string
+	var primaryStrategy string
+	var readinessPolicy string
+	var registrationPolicy string
+	var tlsString string
 	var tokendingsURL string
 
 	flag.StringVar(&cfg.AuthTokenPath, "auth-token-path", os.Getenv("AUTH_TOKEN_PATH"), "Path to service account token file for Tokendings authentication. If empty, falls back to client assertion with private key.")
-	flag.StringVar(&clientJwkJson, "client-jwk-json", os.Getenv("JWKER_PRIVATE_JWK"), "json with private JWK credential")
+	flag.DurationVar(&cfg.ClientAssertionLifetime, "client-assertion-lifetime", tokendings.DefaultClientAssertionLifetime, "Lifetime of client assertions used to authenticate to Tokendings. Assertions are reused until a quarter of the lifetime remains.")
+	flag.DurationVar(&cfg.ClientAssertionReplayWindow, "client-assertion-replay-window", 0, "If set, limits how long a client assertion, and thus its jti, is reused. 0 reuses assertions until near expiry.")
+	flag.StringVar(&clientActiveKeyID, "client-jwk-active-kid", os.Getenv("JWKER_PRIVATE_JWK_ACTIVE_KID"), "Key ID of the key in --client-jwk-json that signs assertions and software statements. Required if it is a JWK set with multiple keys.")
+	flag.StringVar(&clientJwkFile, "client-jwk-file", os.Getenv("JWKER_PRIVATE_JWK_FILE"), "Path to a file with the private JWK credential or JWK set, as in --client-jwk-json. The keys are reloaded when the file changes.")
+	flag.StringVar(&clientJwkJson, "client-jwk-json", os.Getenv("JWKER_PRIVATE_JWK"), "json with private JWK credential, or a JWK set of private keys")
 	flag.StringVar(&cfg.ClientID, "client-id", os.Getenv("JWKER_CLIENT_ID"), "Client ID of Jwker at Auth Provider.")
 	flag.StringVar(&cfg.ClusterName, "cluster-name", os.Getenv("CLUSTER_NAME"), "nais cluster")
+	flag.BoolVar(&dryRun, "dry-run", false, "Log the changes jwker would make to Kubernetes and Tokendings as structured diffs, without making them. See README.")
 	flag.BoolVar(&cfg.LeaderElection, "leader-election", false, "Enable leader election for controller manager.")
+	flag.DurationVar(&cfg.LivenessReconcileTimeout, "liveness-reconcile-timeout", 15*time.Minute, "How long a single reconcile may run before the liveness check fails. 0 disables the check.")
 	flag.StringVar(&cfg.LogLevel, "log-level", os.Getenv("LOG_LEVEL"), "Log level for jwker")
 	flag.IntVar(&cfg.MaxConcurrentReconciles, "max-concurrent-reconciles", 20, "Max concurrent reconciles for controller.")
 	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", ":8181", "The address the metric endpoint binds to.")
+	flag.DurationVar(&cfg.TokendingsMetadataRefresh, "tokendings-metadata-refresh-interval", 10*time.Minute, "How often the authorization server metadata of each Tokendings instance is refreshed. 0 disables refreshes.")
+	flag.DurationVar(&cfg.ResyncPeriod, "resync-period", 0, "How often every Jwker is re-registered with Tokendings, even if unchanged. Resyncs are spread out across the period. 0 disables resyncs.")
 	flag.StringVar(&cfg.ProbeAddr, "probe-addr", ":8180", "The address the health probe listener binds to.")
 	flag.StringVar(&tokendingsURL, "tokendings-base-url", os.Getenv("TOKENDINGS_URL"), "The base URL to Tokendings.")
 	flag.StringVar(&instanceString, "tokendings-instances", os.Getenv("TOKENDINGS_INSTANCES"), "Comma separated list of baseUrls to Tokendings instances.")
+	flag.StringVar(&authenticatorsString, "tokendings-authenticators", os.Getenv("TOKENDINGS_AUTHENTICATORS"), "Comma separated list of baseUrl=authenticator pairs for Tokendings instances that authenticate differently from --auth-token-path. See README for the authenticator forms. The arguments of exec plugins cannot contain commas or whitespace.")
+	flag.StringVar(&backendsString, "tokendings-backends", os.Getenv("TOKENDINGS_BACKENDS"), "Comma separated list of baseUrl=backend pairs for instances that register clients with another protocol than Tokendings', where backend is 'tokendings' or 'rfc7591'. See README.")
+	flag.StringVar(&decommissionedString, "tokendings-decommissioned-instances", os.Getenv("TOKENDINGS_DECOMMISSIONED_INSTANCES"), "Comma separated list of baseUrls to Tokendings instances that are being retired. Known clients are deleted from these.")
+	defaultHTTP := tokendings.DefaultHTTPOptions()
+	flag.DurationVar(&cfg.TokendingsHTTP.Timeout, "tokendings-timeout", defaultHTTP.Timeout, "Timeout for a single request to Tokendings.")
+	flag.DurationVar(&cfg.TokendingsHTTP.IdleConnTimeout, "tokendings-idle-conn-timeout", defaultHTTP.IdleConnTimeout, "How long idle keep-alive connections to Tokendings are kept open.")
+	flag.IntVar(&cfg.TokendingsHTTP.MaxIdleConnsPerHost, "tokendings-max-idle-conns-per-host", defaultHTTP.MaxIdleConnsPerHost, "Max idle keep-alive connections per Tokendings instance.")
+	flag.IntVar(&cfg.TokendingsHTTP.MaxConnsPerHost, "tokendings-max-conns-per-host", defaultHTTP.MaxConnsPerHost, "Max connections per Tokendings instance. 0 means no limit.")
+	flag.StringVar(&tlsString, "tokendings-tls", os.Getenv("TOKENDINGS_TLS"), "Comma separated list of baseUrl=options pairs with TLS settings for Tokendings instances, where options are semicolon separated ca-file, cert-file, key-file, min-version and server-name settings. See README.")
+	flag.IntVar(&cfg.TokendingsParallelism, "tokendings-parallelism", 4, "Max number of Tokendings instances to register a client with concurrently.")
+	flag.StringVar(&primaryStrategy, "tokendings-primary-strategy", string(tokendings.PrimaryStatic), "How the Tokendings instance written to secrets is selected: 'static', 'first-healthy' or 'namespace'.")
+	flag.StringVar(&primaryNamespaces, "tokendings-primary-namespaces", os.Getenv("TOKENDINGS_PRIMARY_NAMESPACES"), "Comma separated list of namespace=baseUrl pairs used by the 'namespace' primary strategy.")
+	flag.DurationVar(&cfg.TokendingsProbeInterval, "tokendings-probe-interval", 30*time.Second, "How often each Tokendings instance is probed for the readiness check. Must be positive.")
+	flag.StringVar(&readinessPolicy, "tokendings-readiness-policy", string(tokendings.ReadinessPolicyAny), "Which instances must be healthy for jwker to be ready: 'any' or 'all'.")
+	flag.StringVar(&registrationPolicy, "tokendings-registration-policy", string(tokendings.RegistrationPolicyAll), "Which instances must succeed before the secret is written: 'all', 'quorum' or 'primary'.")
+	defaultRetry := tokendings.DefaultRetryOptions()
+	flag.IntVar(&cfg.TokendingsRetry.MaxAttempts, "tokendings-retry-max-attempts", defaultRetry.MaxAttempts, "Max attempts for a request to Tokendings within a single reconcile, including the first.")
+	flag.DurationVar(&cfg.TokendingsRetry.InitialBackoff, "tokendings-retry-initial-backoff", defaultRetry.InitialBackoff, "Initial backoff between retried requests to Tokendings.")
+	flag.DurationVar(&cfg.TokendingsRetry.MaxBackoff, "tokendings-retry-max-backoff", defaultRetry.MaxBackoff, "Max backoff between retried requests to Tokendings.")
+	defaultBreaker := tokendings.DefaultCircuitBreakerOptions()
+	flag.IntVar(&cfg.TokendingsCircuitBreaker.FailureThreshold, "tokendings-circuit-breaker-threshold", defaultBreaker.FailureThreshold, "Consecutive failures before requests to a Tokendings instance are stopped. 0 disables the circuit breaker.")
+	flag.DurationVar(&cfg.TokendingsCircuitBreaker.OpenDuration, "tokendings-circuit-breaker-open-duration", defaultBreaker.OpenDuration, "How long requests to a failing Tokendings instance are stopped before a probe request is let through.")
 	flag.Parse()
 
 	if cfg.LogLevel == "" {
 		cfg.LogLevel = "info"
 	}
 
-	j, err := jwk.Parse([]byte(clientJwkJson))
+	if dryRun {
+		cfg.DryRun = &dryrun.Recorder{OnChange: reportDryRunChange}
+		// a dry run must not take over leadership from the jwker that makes the changes
+		cfg.LeaderElection = false
+	}
+
+	// the readiness check depends on the probes, so they cannot be disabled
+	if cfg.TokendingsProbeInterval <= 0 {
+		return nil, fmt.Errorf("invalid tokendings probe interval %s; must be positive", cfg.TokendingsProbeInterval)
+	}
+
+	keys, err := clientKeys(clientJwkJson, clientJwkFile, clientActiveKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client JWK: %w", err)
+	}
+	cfg.ClientKeys = keys
+
+	authenticators, err := tokendings.ParseAuthenticators(authenticatorsString, cfg.ClientID, cfg.ClientKeys)
+	if err != nil {
+		return nil, err
+	}
+	for _, authenticator := range authenticators {
+		if token, ok := authenticator.(*tokendings.ServiceAccountToken); ok {
+			token.OnExpiry = reportTokenExpiry(token.Path)
+		}
+	}
+
+	var serviceAccountToken tokendings.Authenticator
+	if cfg.AuthTokenPath != "" {
+		// shared by all instances, so that the token is cached and watched once
+		serviceAccountToken = tokendings.NewServiceAccountToken(cfg.AuthTokenPath, reportTokenExpiry(cfg.AuthTokenPath))
+	}
+
+	cfg.TokendingsRegistrationPolicy, err = tokendings.ParseRegistrationPolicy(registrationPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.TokendingsReadinessPolicy, err = tokendings.ParseReadinessPolicy(readinessPolicy)
 	if err != nil {
 		return nil, err
 	}
-	cfg.ClientJwk = j
 
 	maxConcurrentReconciles, ok := os.LookupEnv("JWKER_MAX_CONCURRENT_RECONCILES")
 	if ok {
@@ -65,14 +165,29 @@ func New(ctx context.Context) (*Config, error) {
 		}
 	}
 
+	tlsOptions, err := tokendings.ParseTLSOptions(tlsString)
+	if err != nil {
+		return nil, err
+	}
+
+	backends, err := tokendings.ParseBackends(backendsString)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := tokendings.NewHTTPClient(cfg.TokendingsHTTP)
 	instances := make([]tokendings.Instance, 0)
 	raw := strings.TrimSpace(instanceString)
 	if raw == "" {
 		raw = tokendingsURL
 	}
 	for u := range strings.SplitSeq(raw, ",") {
-		_, err := url.Parse(strings.TrimSpace(u))
-		if err != nil {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+
+		if _, err := url.Parse(u); err != nil {
 			return nil, fmt.Errorf("invalid base url for tokendings instance: %w", err)
 		}
 
@@ -81,12 +196,19 @@ func New(ctx context.Context) (*Config, error) {
 			return nil, fmt.Errorf("constructing well-known URL for tokendings instance %s: %w", u, err)
 		}
 
-		metadata, err := oauth.NewMetadataOAuth(ctx, wellKnownURL)
+		instanceClient, err := cfg.httpClient(u, httpClient, tlsOptions)
 		if err != nil {
-			return nil, fmt.Errorf("resolving metadata for tokendings instance %s: %w", u, err)
+			return nil, err
 		}
 
-		instances = append(instances, tokendings.NewInstance(u, cfg.ClientID, cfg.ClientJwk, metadata, cfg.AuthTokenPath))
+		// an unreachable instance is resolved in the background; see tokendings.ResolveMetadata
+		instance := cfg.newInstance(u, instanceClient, authenticators, backends, serviceAccountToken)
+		instance.Metadata = tokendings.NewMetadata(wellKnownURL, nil)
+		instance.Metadata.HTTPClient = instanceClient
+		if err := resolveMetadata(ctx, instance.Metadata, cfg.TokendingsHTTP.Timeout); err != nil {
+			slog.Warn(fmt.Sprintf("resolving metadata for tokendings instance %s; retrying in the background", u), "error", err)
+		}
+		instances = append(instances, instance)
 	}
 
 	if len(instances) == 0 {
@@ -94,5 +216,155 @@ func New(ctx context.Context) (*Config, error) {
 	}
 	cfg.TokendingsInstances = instances
 
+	cfg.TokendingsPrimary.Strategy, err = tokendings.ParsePrimaryStrategy(primaryStrategy)
+	if err != nil {
+		return nil, err
+	}
+	cfg.TokendingsPrimary.Namespaces, err = tokendings.ParseNamespaceMapping(primaryNamespaces, instances)
+	if err != nil {
+		return nil, err
+	}
+
+	decommissioned := make([]tokendings.Instance, 0)
+	for u := range strings.SplitSeq(decommissionedString, ",") {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+
+		if _, err := url.Parse(u); err != nil {
+			return nil, fmt.Errorf("invalid base url for decommissioned tokendings instance: %w", err)
+		}
+		if slices.ContainsFunc(instances, func(i tokendings.Instance) bool { return i.BaseURL == u }) {
+			return nil, fmt.Errorf("tokendings instance %s is both active and decommissioned", u)
+		}
+
+		instanceClient, err := cfg.httpClient(u, httpClient, tlsOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		// clients are only deleted from decommissioned instances, which does not need their metadata
+		decommissioned = append(decommissioned, cfg.newInstance(u, instanceClient, authenticators, backends, serviceAccountToken))
+	}
+	cfg.TokendingsDecommissionedInstances = decommissioned
+
+	known := slices.Concat(instances, decommissioned)
+	if err := requireKnownInstances("authenticator", authenticators, known); err != nil {
+		return nil, err
+	}
+	if err := requireKnownInstances("backend", backends, known); err != nil {
+		return nil, err
+	}
+	if err := requireKnownInstances("TLS options", tlsOptions, known); err != nil {
+		return nil, err
+	}
+
 	return cfg, nil
 }
+
+// requireKnownInstances returns an error if any of the base URLs that something is configured for is not one of the known instances.
+func requireKnownInstances[V any](what string, configured map[string]V, known []tokendings.Instance) error {
+	for _, baseURL := range slices.Sorted(maps.Keys(configured)) {
+		if !slices.ContainsFunc(known, func(i tokendings.Instance) bool { return i.BaseURL == baseURL }) {
+			return fmt.Errorf("%s configured for %s, which is not a configured tokendings instance", what, baseURL)
+		}
+	}
+	return nil
+}
+
+func clientKeys(clientJwkJson, clientJwkFile, activeKeyID string) (*jwk.SigningKeyStore, error) {
+	switch {
+	case clientJwkJson != "" && clientJwkFile != "":
+		return nil, fmt.Errorf("only one of --client-jwk-json and --client-jwk-file may be set")
+	case clientJwkFile != "":
+		return jwk.LoadSigningKeyStore(clientJwkFile, activeKeyID, reportClientKeysReload(clientJwkFile))
+	}
+
+	keys, err := jwk.ParseSigningKeys([]byte(clientJwkJson), activeKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return jwk.NewSigningKeyStore(keys), nil
+}
+
+func reportClientKeysReload(path string) func(*jwk.SigningKeys, error) {
+	return func(keys *jwk.SigningKeys, err error) {
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to reload client JWK from %s; keeping active key %q", path, keys.Active.KeyID), "error", err)
+			jwkermetrics.ClientJwkReloadCount.WithLabelValues("failure").Inc()
+			return
+		}
+		slog.Info(fmt.Sprintf("reloaded client JWK from %s; active key is %q", path, keys.Active.KeyID), "keyIDs", keys.KeyIDs())
+		jwkermetrics.ClientJwkReloadCount.WithLabelValues("success").Inc()
+		jwkermetrics.ClientJwkLastReloadSuccessTimestamp.SetToCurrentTime()
+	}
+}
+
+func reportDryRunChange(change dryrun.Change) {
+	jwkermetrics.DryRunChangesCount.WithLabelValues(change.Kind, change.Action).Inc()
+}
+
+func reportTokenExpiry(path string) func(time.Duration) {
+	return func(remaining time.Duration) {
+		jwkermetrics.TokendingsAuthTokenExpirySeconds.WithLabelValues(path).Set(remaining.Seconds())
+	}
+}
+
+func resolveMetadata(ctx context.Context, metadata *tokendings.Metadata, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return metadata.Resolve(ctx)
+}
+
+// httpClient returns a client with the TLS settings for baseURL, or defaultClient if it has none.
+func (cfg *Config) httpClient(baseURL string, defaultClient *http.Client, tlsOptions map[string]tokendings.TLSOptions) (*http.Client, error) {
+	options, ok := tlsOptions[baseURL]
+	if !ok {
+		return defaultClient, nil
+	}
+
+	tlsConfig, certificate, err := options.Config(func(err error) {
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to reload client certificate for tokendings instance %s; keeping the current certificate", baseURL), "error", err)
+			return
+		}
+		slog.Info(fmt.Sprintf("reloaded client certificate for tokendings instance %s", baseURL))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("TLS options for tokendings instance %s: %w", baseURL, err)
+	}
+	if certificate != nil {
+		cfg.TokendingsClientCertificates = append(cfg.TokendingsClientCertificates, certificate)
+	}
+
+	httpOptions := cfg.TokendingsHTTP
+	httpOptions.TLS = tlsConfig
+	return tokendings.NewHTTPClient(httpOptions), nil
+}
+
+func (cfg *Config) newInstance(baseURL string, httpClient *http.Client, authenticators map[string]tokendings.Authenticator, backends map[string]tokendings.Backend, serviceAccountToken tokendings.Authenticator) tokendings.Instance {
+	instance := tokendings.NewInstance(baseURL, cfg.ClientID, cfg.ClientKeys, nil, "", httpClient)
+	instance.Backend = backends[baseURL]
+	if cfg.DryRun != nil {
+		instance.Backend = tokendings.DryRunBackend{Backend: instance.Backend, Recorder: cfg.DryRun}
+	}
+	if authenticator, ok := authenticators[baseURL]; ok {
+		instance.Authenticator = authenticator
+	} else if serviceAccountToken != nil {
+		instance.Authenticator = serviceAccountToken
+	}
+	if signer, ok := instance.Authenticator.(*tokendings.ClientAssertionSigner); ok {
+		signer.Lifetime = cfg.ClientAssertionLifetime
+		signer.ReplayWindow = cfg.ClientAssertionReplayWindow
+	}
+	instance.Retry = cfg.TokendingsRetry
+	instance.CircuitBreaker = tokendings.NewCircuitBreaker(cfg.TokendingsCircuitBreaker, func(state tokendings.CircuitState) {
+		slog.Info(fmt.Sprintf("circuit breaker for tokendings instance %s is %s", baseURL, state))
+		jwkermetrics.TokendingsCircuitBreakerState.WithLabelValues(baseURL).Set(float64(state))
+	})
+	return instance
+}
@@ -0,0 +1,181 @@
+package dryrun
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NewClient returns a client that sends every write to the API server as a dry run, so that it is validated but not persisted,
+// and records each successful write as a change. Reads are passed through.
+func NewClient(c client.Client, recorder *Recorder) client.Client {
+	return &dryRunClient{Client: client.NewDryRunClient(c), recorder: recorder}
+}
+
+type dryRunClient struct {
+	client.Client
+	recorder *Recorder
+}
+
+func (c *dryRunClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	diff, err := Diff(nil, obj)
+	if err != nil {
+		return err
+	}
+	if err := c.Client.Create(ctx, obj, opts...); err != nil {
+		return err
+	}
+	c.recorder.Record(ctx, c.change(obj, "create", diff))
+	return nil
+}
+
+func (c *dryRunClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	diff, err := c.diff(ctx, obj)
+	if err != nil {
+		return err
+	}
+	if err := c.Client.Update(ctx, obj, opts...); err != nil {
+		return err
+	}
+	c.recorder.Record(ctx, c.change(obj, "update", diff))
+	return nil
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	current, err := c.current(ctx, obj)
+	if err != nil {
+		return err
+	}
+	// the patched object is only known from the response
+	if err := c.Client.Patch(ctx, obj, patch, opts...); err != nil {
+		return err
+	}
+	diff, err := Diff(current, obj)
+	if err != nil {
+		return err
+	}
+	c.recorder.Record(ctx, c.change(obj, "patch", diff))
+	return nil
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.Client.Delete(ctx, obj, opts...); err != nil {
+		return err
+	}
+	c.recorder.Record(ctx, c.change(obj, "delete", nil))
+	return nil
+}
+
+func (c *dryRunClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	if err := c.Client.DeleteAllOf(ctx, obj, opts...); err != nil {
+		return err
+	}
+	c.recorder.Record(ctx, c.change(obj, "delete all of", nil))
+	return nil
+}
+
+func (c *dryRunClient) Status() client.SubResourceWriter {
+	return c.SubResource("status")
+}
+
+func (c *dryRunClient) SubResource(subResource string) client.SubResourceClient {
+	return &dryRunSubResourceClient{SubResourceClient: c.Client.SubResource(subResource), client: c, subResource: subResource}
+}
+
+// current returns the object as it is stored in the cluster, or nil if it does not exist.
+func (c *dryRunClient) current(ctx context.Context, obj client.Object) (client.Object, error) {
+	current, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return nil, fmt.Errorf("copying %T", obj)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return current, nil
+}
+
+// diff returns how obj differs from the object stored in the cluster.
+func (c *dryRunClient) diff(ctx context.Context, obj client.Object) ([]Difference, error) {
+	current, err := c.current(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+	return Diff(current, obj)
+}
+
+func (c *dryRunClient) change(obj runtime.Object, action string, diff []Difference) Change {
+	change := Change{Kind: fmt.Sprintf("%T", obj), Action: action, Diff: diff}
+	if gvk, err := c.GroupVersionKindFor(obj); err == nil {
+		change.Kind = gvk.Kind
+	}
+	if o, ok := obj.(client.Object); ok {
+		change.Name = client.ObjectKeyFromObject(o).String()
+	}
+	return change
+}
+
+// EventRecorder records events as changes instead of emitting them.
+type EventRecorder struct {
+	Recorder *Recorder
+}
+
+func (e EventRecorder) Eventf(regarding runtime.Object, _ runtime.Object, eventtype, reason, action, note string, args ...any) {
+	change := Change{
+		Kind:   "Event",
+		Action: "create",
+		Diff: []Difference{
+			{Path: ".type", New: eventtype},
+			{Path: ".reason", New: reason},
+			{Path: ".action", New: action},
+			{Path: ".note", New: fmt.Sprintf(note, args...)},
+		},
+	}
+	if o, ok := regarding.(client.Object); ok {
+		change.Name = client.ObjectKeyFromObject(o).String()
+	}
+	e.Recorder.Record(context.Background(), change)
+}
+
+type dryRunSubResourceClient struct {
+	client.SubResourceClient
+	client      *dryRunClient
+	subResource string
+}
+
+func (sw *dryRunSubResourceClient) Create(ctx context.Context, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	if err := sw.SubResourceClient.Create(ctx, obj, subResource, opts...); err != nil {
+		return err
+	}
+	sw.client.recorder.Record(ctx, sw.client.change(obj, "create "+sw.subResource+" of", nil))
+	return nil
+}
+
+func (sw *dryRunSubResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	diff, err := sw.client.diff(ctx, obj)
+	if err != nil {
+		return err
+	}
+	if err := sw.SubResourceClient.Update(ctx, obj, opts...); err != nil {
+		return err
+	}
+	sw.client.recorder.Record(ctx, sw.client.change(obj, "update "+sw.subResource+" of", diff))
+	return nil
+}
+
+func (sw *dryRunSubResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	current, err := sw.client.current(ctx, obj)
+	if err != nil {
+		return err
+	}
+	if err := sw.SubResourceClient.Patch(ctx, obj, patch, opts...); err != nil {
+		return err
+	}
+	diff, err := Diff(current, obj)
+	if err != nil {
+		return err
+	}
+	sw.client.recorder.Record(ctx, sw.client.change(obj, "patch "+sw.subResource+" of", diff))
+	return nil
+}
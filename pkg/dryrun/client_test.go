@@ -0,0 +1,72 @@
+package dryrun
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "team1", Labels: map[string]string{"app": "app1"}},
+		Data:       map[string][]byte{"key": []byte("value")},
+	}
+	live := fake.NewClientBuilder().WithObjects(existing).Build()
+
+	changes := make([]Change, 0)
+	c := NewClient(live, &Recorder{OnChange: func(change Change) { changes = append(changes, change) }})
+
+	t.Run("create", func(t *testing.T) {
+		changes = changes[:0]
+		created := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "created", Namespace: "team1"}}
+		require.NoError(t, c.Create(ctx, created))
+
+		err := live.Get(ctx, client.ObjectKeyFromObject(created), &corev1.Secret{})
+		assert.True(t, k8serrors.IsNotFound(err), "created secret must not be persisted")
+
+		require.Len(t, changes, 1)
+		assert.Equal(t, Change{
+			Kind:   "Secret",
+			Name:   "team1/created",
+			Action: "create",
+			Diff: []Difference{
+				{Path: ".metadata.name", New: "created"},
+				{Path: ".metadata.namespace", New: "team1"},
+			},
+		}, changes[0])
+	})
+
+	t.Run("update", func(t *testing.T) {
+		changes = changes[:0]
+		updated := &corev1.Secret{}
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(existing), updated))
+		updated.Labels["app"] = "app2"
+		require.NoError(t, c.Update(ctx, updated))
+
+		persisted := &corev1.Secret{}
+		require.NoError(t, live.Get(ctx, client.ObjectKeyFromObject(existing), persisted))
+		assert.Equal(t, "app1", persisted.Labels["app"])
+
+		require.Len(t, changes, 1)
+		assert.Equal(t, "update", changes[0].Action)
+		assert.Equal(t, []Difference{{Path: ".metadata.labels.app", Old: "app1", New: "app2"}}, changes[0].Diff)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		changes = changes[:0]
+		require.NoError(t, c.Delete(ctx, existing.DeepCopy()))
+		require.NoError(t, live.Get(ctx, client.ObjectKeyFromObject(existing), &corev1.Secret{}))
+
+		require.Len(t, changes, 1)
+		assert.Equal(t, "delete", changes[0].Action)
+		assert.Equal(t, "team1/existing", changes[0].Name)
+	})
+}
@@ -0,0 +1,168 @@
+// Package dryrun records the changes that jwker would make to Kubernetes and Tokendings, without making them.
+package dryrun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Change is a write that was not made.
+type Change struct {
+	// Kind of the object that would have been written, e.g. "Secret", "Jwker", "Event" or "Client" for a Tokendings client.
+	Kind string `json:"kind"`
+	// Name identifies the object, e.g. namespace/name.
+	Name string `json:"name"`
+	// Action is what would have been done, e.g. "create", "update", "delete" or "register".
+	Action string `json:"action"`
+	// Diff lists the fields that would have changed, if known.
+	Diff []Difference `json:"diff,omitempty"`
+}
+
+// Difference is a change to a single field. An empty Old or New means that the field would have been added or removed.
+type Difference struct {
+	Path string `json:"path"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+// Recorder logs changes as structured diffs. A nil Recorder discards them.
+type Recorder struct {
+	// OnChange, if set, is called with every change, e.g. to export metrics.
+	OnChange func(Change)
+}
+
+// Record logs the change with the logger in ctx.
+func (r *Recorder) Record(ctx context.Context, change Change) {
+	if r == nil {
+		return
+	}
+
+	ctrl.LoggerFrom(ctx).WithValues("subsystem", "dry-run").Info(fmt.Sprintf("dry run: would %s %s %q", change.Action, change.Kind, change.Name), "change", change)
+	if r.OnChange != nil {
+		r.OnChange(change)
+	}
+}
+
+const redacted = "(redacted)"
+
+// ignoredPaths are maintained by the API server, and are not changed by writes.
+var ignoredPaths = []string{
+	".metadata.creationTimestamp",
+	".metadata.generation",
+	".metadata.managedFields",
+	".metadata.resourceVersion",
+	".metadata.uid",
+}
+
+// Diff returns the fields that differ between two versions of an object, sorted by path. Either version may be nil.
+// Nested fields are compared one by one, while lists are compared as a whole. The values of a secret's data are redacted.
+func Diff(old, new runtime.Object) ([]Difference, error) {
+	oldFields, err := fields(old)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := fields(new)
+	if err != nil {
+		return nil, err
+	}
+
+	sensitive := isSecret(old) || isSecret(new)
+	paths := slices.Collect(maps.Keys(newFields))
+	for path := range oldFields {
+		if _, ok := newFields[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	diff := make([]Difference, 0)
+	for _, path := range paths {
+		d := Difference{Path: path, Old: oldFields[path], New: newFields[path]}
+		if d.Old == d.New {
+			continue
+		}
+		if sensitive && strings.HasPrefix(path, ".data.") {
+			d.Old, d.New = redact(d.Old), redact(d.New)
+		}
+		diff = append(diff, d)
+	}
+	return diff, nil
+}
+
+// fields flattens obj to the values of its fields, keyed by path.
+func fields(obj runtime.Object) (map[string]string, error) {
+	fields := make(map[string]string)
+	if obj == nil {
+		return fields, nil
+	}
+
+	// string data is merged into the data by the API server
+	if sec, ok := obj.(*corev1.Secret); ok && len(sec.StringData) > 0 {
+		sec = sec.DeepCopy()
+		if sec.Data == nil {
+			sec.Data = make(map[string][]byte, len(sec.StringData))
+		}
+		for key, value := range sec.StringData {
+			sec.Data[key] = []byte(value)
+		}
+		sec.StringData = nil
+		obj = sec
+	}
+
+	unstructured, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, fmt.Errorf("converting %T: %w", obj, err)
+	}
+	if err := flatten("", unstructured, fields); err != nil {
+		return nil, err
+	}
+
+	for _, path := range ignoredPaths {
+		delete(fields, path)
+	}
+	return fields, nil
+}
+
+func flatten(path string, value any, fields map[string]string) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case map[string]any:
+		for key, value := range v {
+			if err := flatten(path+"."+key, value, fields); err != nil {
+				return err
+			}
+		}
+		return nil
+	case string:
+		fields[path] = v
+		return nil
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("encoding %s: %w", path, err)
+		}
+		fields[path] = string(raw)
+		return nil
+	}
+}
+
+func isSecret(obj runtime.Object) bool {
+	_, ok := obj.(*corev1.Secret)
+	return ok
+}
+
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redacted
+}
@@ -0,0 +1,68 @@
+package dryrun
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDiff(t *testing.T) {
+	old := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "secret",
+			Namespace:       "team1",
+			ResourceVersion: "1",
+			Labels:          map[string]string{"app": "app1", "team": "team1"},
+		},
+		Data: map[string][]byte{
+			"TOKEN_X_CLIENT_ID":   []byte("cluster1:team1:app1"),
+			"TOKEN_X_PRIVATE_JWK": []byte("old"),
+			"UNUSED":              []byte("unused"),
+		},
+	}
+	new := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "secret",
+			Namespace:       "team1",
+			ResourceVersion: "2",
+			Labels:          map[string]string{"app": "app1", "type": "jwker"},
+		},
+		StringData: map[string]string{
+			"TOKEN_X_CLIENT_ID":   "cluster1:team1:app1",
+			"TOKEN_X_PRIVATE_JWK": "new",
+			"TOKEN_X_ISSUER":      "https://tokendings",
+		},
+	}
+
+	diff, err := Diff(old, new)
+	require.NoError(t, err)
+	assert.Equal(t, []Difference{
+		{Path: ".data.TOKEN_X_ISSUER", New: redacted},
+		{Path: ".data.TOKEN_X_PRIVATE_JWK", Old: redacted, New: redacted},
+		{Path: ".data.UNUSED", Old: redacted},
+		{Path: ".metadata.labels.team", Old: "team1"},
+		{Path: ".metadata.labels.type", New: "jwker"},
+	}, diff)
+
+	t.Run("creation", func(t *testing.T) {
+		diff, err := Diff(nil, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config", Finalizers: []string{"a", "b"}},
+			Data:       map[string]string{"key": "value"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []Difference{
+			{Path: ".data.key", New: "value"},
+			{Path: ".metadata.finalizers", New: `["a","b"]`},
+			{Path: ".metadata.name", New: "config"},
+		}, diff)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		diff, err := Diff(old, old.DeepCopy())
+		require.NoError(t, err)
+		assert.Empty(t, diff)
+	})
+}
@@ -0,0 +1,47 @@
+// Package filewatch detects changes to files that are replaced on disk, such as projected volumes and mounted secrets.
+package filewatch
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch calls onChange whenever the file at path may have changed, until ctx is done.
+// The parent directory is watched instead of the file itself, as Kubernetes updates volumes by swapping a symlink
+// in the directory, which a watch on the file would not see. onChange may thus be called for unrelated changes,
+// and is also called if the watcher reports an error, such as dropped events.
+func Watch(ctx context.Context, path string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	dir := filepath.Dir(path)
+	if err := watcher.Add(dir); err != nil {
+		return fmt.Errorf("watching %s: %w", dir, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) || event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+				onChange()
+			}
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			// events may have been dropped, e.g. on overflow
+			onChange()
+		}
+	}
+}
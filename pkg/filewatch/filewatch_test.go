@@ -0,0 +1,46 @@
+package filewatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(path, []byte("first"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan struct{}, 10)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, path, func() { changes <- struct{}{} })
+	}()
+
+	// like Kubernetes, replace the file instead of writing to it
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		next := filepath.Join(dir, "next")
+		assert.NoError(c, os.WriteFile(next, []byte("second"), 0o600))
+		assert.NoError(c, os.Rename(next, path))
+
+		select {
+		case <-changes:
+		default:
+			c.Errorf("no change observed")
+		}
+	}, 5*time.Second, 50*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestWatch_MissingDirectory(t *testing.T) {
+	err := Watch(context.Background(), filepath.Join(t.TempDir(), "missing", "token"), func() {})
+	assert.Error(t, err)
+}
@@ -0,0 +1,74 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Watchdog keeps track of running reconciles, so that a liveness check can detect a wedged reconcile queue.
+// A reconcile that never returns keeps its worker, and its object is never reconciled again until the process restarts.
+type Watchdog struct {
+	// Timeout is how long a single reconcile may run before the process is considered wedged. Values below 1 disable the check.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	next    uint64
+	running map[uint64]time.Time
+	now     func() time.Time
+}
+
+func NewWatchdog(timeout time.Duration) *Watchdog {
+	return &Watchdog{
+		Timeout: timeout,
+		running: make(map[uint64]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Track records the start of a reconcile. The returned function must be called when the reconcile returns.
+// Track is a no-op on a nil watchdog.
+func (w *Watchdog) Track() func() {
+	if w == nil {
+		return func() {}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	id := w.next
+	w.next++
+	w.running[id] = w.now()
+
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.running, id)
+	}
+}
+
+// Check is a liveness check that fails if any reconcile has been running for longer than Timeout.
+func (w *Watchdog) Check(_ *http.Request) error {
+	if w.Timeout < 1 {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	stuck := 0
+	var oldest time.Duration
+	for _, started := range w.running {
+		if elapsed := now.Sub(started); elapsed > w.Timeout {
+			stuck++
+			oldest = max(oldest, elapsed)
+		}
+	}
+
+	if stuck > 0 {
+		return fmt.Errorf("%d reconciles have been running for longer than %s; the oldest for %s", stuck, w.Timeout, oldest.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchdog(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	w := NewWatchdog(time.Minute)
+	w.now = func() time.Time { return now }
+
+	assert.NoError(t, w.Check(nil))
+
+	done := w.Track()
+	now = now.Add(30 * time.Second)
+	fast := w.Track()
+	assert.NoError(t, w.Check(nil))
+
+	now = now.Add(time.Minute)
+	fast()
+	assert.ErrorContains(t, w.Check(nil), "1 reconciles have been running for longer than 1m0s; the oldest for 1m30s")
+
+	done()
+	assert.NoError(t, w.Check(nil))
+
+	t.Run("disabled", func(t *testing.T) {
+		w := NewWatchdog(0)
+		w.now = func() time.Time { return now }
+		_ = w.Track()
+		now = now.Add(time.Hour)
+		assert.NoError(t, w.Check(nil))
+	})
+
+	t.Run("nil watchdog", func(t *testing.T) {
+		var w *Watchdog
+		w.Track()()
+	})
+}
@@ -1,8 +1,15 @@
 package jwk
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	cryptorand "crypto/rand"
 	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
 
 	"github.com/go-jose/go-jose/v4"
 	"github.com/google/uuid"
@@ -30,6 +37,139 @@ func Parse(json []byte) (*jose.JSONWebKey, error) {
 	return jwk, nil
 }
 
+// minimumRSAKeyBits is the smallest RSA key accepted for signing, as required by RFC 7518, section 3.3.
+const minimumRSAKeyBits = 2048
+
+// SigningAlgorithm returns the algorithm to sign with the private key jwk.
+// The algorithm is derived from the key type: RS256 for RSA keys, ES256, ES384 or ES512 for EC keys
+// depending on the curve, and EdDSA for Ed25519 keys. An "alg" in the JWK takes precedence if it is valid for the key;
+// for RSA keys this allows PS256 and friends.
+func SigningAlgorithm(jwk *jose.JSONWebKey) (jose.SignatureAlgorithm, error) {
+	if jwk == nil || jwk.Key == nil {
+		return "", fmt.Errorf("no key")
+	}
+
+	var supported []jose.SignatureAlgorithm
+	switch key := jwk.Key.(type) {
+	case *rsa.PrivateKey:
+		if bits := key.N.BitLen(); bits < minimumRSAKeyBits {
+			return "", fmt.Errorf("RSA key %q has %d bits; at least %d are required", jwk.KeyID, bits, minimumRSAKeyBits)
+		}
+		supported = []jose.SignatureAlgorithm{jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512}
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			supported = []jose.SignatureAlgorithm{jose.ES256}
+		case elliptic.P384():
+			supported = []jose.SignatureAlgorithm{jose.ES384}
+		case elliptic.P521():
+			supported = []jose.SignatureAlgorithm{jose.ES512}
+		default:
+			return "", fmt.Errorf("EC key %q uses unsupported curve %s", jwk.KeyID, key.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		supported = []jose.SignatureAlgorithm{jose.EdDSA}
+	default:
+		return "", fmt.Errorf("key %q of type %T is not a supported private signing key", jwk.KeyID, jwk.Key)
+	}
+
+	if jwk.Algorithm == "" {
+		return supported[0], nil
+	}
+	alg := jose.SignatureAlgorithm(jwk.Algorithm)
+	if !slices.Contains(supported, alg) {
+		return "", fmt.Errorf("algorithm %q of key %q is not valid for the key; must be one of %v", jwk.Algorithm, jwk.KeyID, supported)
+	}
+	return alg, nil
+}
+
+// NewSigner returns a signer of JWTs with the private key jwk, using SigningAlgorithm.
+// The key ID is included in the header, so that the verifier can select the matching public key.
+func NewSigner(jwk *jose.JSONWebKey) (jose.Signer, error) {
+	alg, err := SigningAlgorithm(jwk)
+	if err != nil {
+		return nil, err
+	}
+
+	signerOpts := jose.SignerOptions{}
+	signerOpts.WithType("JWT")
+	signerOpts.WithHeader("kid", jwk.KeyID)
+
+	return jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: jwk.Key}, &signerOpts)
+}
+
+// SigningKeys is a set of private signing keys, one of which is active.
+// The inactive keys are kept so that the active key can be rolled back without a new key.
+type SigningKeys struct {
+	Active jose.JSONWebKey
+	Keys   jose.JSONWebKeySet
+}
+
+// ParseSigningKeys parses either a single private JWK or a JWK set, and selects the key with activeKeyID as the active key.
+// activeKeyID may be empty if there is exactly one key. Every key must be a valid signing key with a unique key ID.
+func ParseSigningKeys(data []byte, activeKeyID string) (*SigningKeys, error) {
+	var set jose.JSONWebKeySet
+	var probe struct {
+		Keys json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+
+	if probe.Keys != nil {
+		if err := json.Unmarshal(data, &set); err != nil {
+			return nil, err
+		}
+	} else {
+		key, err := Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = []jose.JSONWebKey{*key}
+	}
+
+	if len(set.Keys) == 0 {
+		return nil, fmt.Errorf("no keys in JWK set")
+	}
+
+	keyIDs := make(map[string]bool, len(set.Keys))
+	for _, key := range set.Keys {
+		if _, err := SigningAlgorithm(&key); err != nil {
+			return nil, err
+		}
+		if len(set.Keys) > 1 && key.KeyID == "" {
+			return nil, fmt.Errorf("every key in a JWK set with multiple keys must have a key ID")
+		}
+		if keyIDs[key.KeyID] {
+			return nil, fmt.Errorf("duplicate key ID %q in JWK set", key.KeyID)
+		}
+		keyIDs[key.KeyID] = true
+	}
+
+	if activeKeyID == "" {
+		if len(set.Keys) > 1 {
+			return nil, fmt.Errorf("JWK set has %d keys; the active key ID must be specified", len(set.Keys))
+		}
+		return &SigningKeys{Active: set.Keys[0], Keys: set}, nil
+	}
+
+	for _, key := range set.Keys {
+		if key.KeyID == activeKeyID {
+			return &SigningKeys{Active: key, Keys: set}, nil
+		}
+	}
+	return nil, fmt.Errorf("active key ID %q not found in JWK set; key IDs are %v", activeKeyID, slices.Sorted(maps.Keys(keyIDs)))
+}
+
+// KeyIDs returns the key IDs of all keys, the active one included.
+func (k SigningKeys) KeyIDs() []string {
+	keyIDs := make([]string, len(k.Keys.Keys))
+	for i, key := range k.Keys.Keys {
+		keyIDs[i] = key.KeyID
+	}
+	return keyIDs
+}
+
 func Generate() (jose.JSONWebKey, error) {
 	privateKey, err := rsa.GenerateKey(cryptorand.Reader, 2048)
 	if err != nil {
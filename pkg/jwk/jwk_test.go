@@ -0,0 +1,138 @@
+package jwk_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nais/jwker/pkg/jwk"
+)
+
+func TestSigningAlgorithm(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	require.NoError(t, err)
+	smallRSAKey, err := rsa.GenerateKey(cryptorand.Reader, 1024)
+	require.NoError(t, err)
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	require.NoError(t, err)
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), cryptorand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(cryptorand.Reader)
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name    string
+		key     any
+		alg     string
+		want    jose.SignatureAlgorithm
+		wantErr string
+	}{
+		{name: "RSA defaults to RS256", key: rsaKey, want: jose.RS256},
+		{name: "RSA with PS256", key: rsaKey, alg: "PS256", want: jose.PS256},
+		{name: "RSA with EC algorithm", key: rsaKey, alg: "ES256", wantErr: "not valid for the key"},
+		{name: "small RSA key", key: smallRSAKey, wantErr: "at least 2048"},
+		{name: "EC P-256", key: p256Key, want: jose.ES256},
+		{name: "EC P-384", key: p384Key, want: jose.ES384},
+		{name: "EC with mismatched curve", key: p384Key, alg: "ES256", wantErr: "not valid for the key"},
+		{name: "Ed25519", key: edKey, want: jose.EdDSA},
+		{name: "public key", key: p256Key.Public(), wantErr: "not a supported private signing key"},
+		{name: "symmetric key", key: []byte("secret"), wantErr: "not a supported private signing key"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			alg, err := jwk.SigningAlgorithm(&jose.JSONWebKey{Key: tt.key, KeyID: "kid", Algorithm: tt.alg})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, alg)
+		})
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(cryptorand.Reader)
+	require.NoError(t, err)
+	key := jose.JSONWebKey{Key: edKey, KeyID: "ed"}
+
+	signer, err := jwk.NewSigner(&key)
+	require.NoError(t, err)
+	signed, err := signer.Sign([]byte("payload"))
+	require.NoError(t, err)
+	raw, err := signed.CompactSerialize()
+	require.NoError(t, err)
+
+	parsed, err := jose.ParseSignedCompact(raw, []jose.SignatureAlgorithm{jose.EdDSA})
+	require.NoError(t, err)
+	assert.Equal(t, "ed", parsed.Signatures[0].Header.KeyID)
+	payload, err := parsed.Verify(key.Public())
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(payload))
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	generate := func(t *testing.T, keyID string) jose.JSONWebKey {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+		require.NoError(t, err)
+		return jose.JSONWebKey{Key: privateKey, KeyID: keyID, Use: "sig"}
+	}
+	marshal := func(t *testing.T, v any) []byte {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return data
+	}
+
+	current := generate(t, "current")
+	previous := generate(t, "previous")
+	set := marshal(t, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{current, previous}})
+
+	t.Run("single key", func(t *testing.T) {
+		keys, err := jwk.ParseSigningKeys(marshal(t, current), "")
+		require.NoError(t, err)
+		assert.Equal(t, "current", keys.Active.KeyID)
+		assert.Equal(t, []string{"current"}, keys.KeyIDs())
+	})
+
+	t.Run("set with active key", func(t *testing.T) {
+		keys, err := jwk.ParseSigningKeys(set, "previous")
+		require.NoError(t, err)
+		assert.Equal(t, "previous", keys.Active.KeyID)
+		assert.False(t, keys.Active.IsPublic())
+		assert.Equal(t, []string{"current", "previous"}, keys.KeyIDs())
+	})
+
+	t.Run("set without active key", func(t *testing.T) {
+		_, err := jwk.ParseSigningKeys(set, "")
+		assert.ErrorContains(t, err, "active key ID must be specified")
+	})
+
+	t.Run("unknown active key", func(t *testing.T) {
+		_, err := jwk.ParseSigningKeys(set, "unknown")
+		assert.ErrorContains(t, err, `active key ID "unknown" not found`)
+	})
+
+	t.Run("duplicate key IDs", func(t *testing.T) {
+		duplicate := marshal(t, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{current, generate(t, "current")}})
+		_, err := jwk.ParseSigningKeys(duplicate, "current")
+		assert.ErrorContains(t, err, "duplicate key ID")
+	})
+
+	t.Run("public key in set", func(t *testing.T) {
+		public := marshal(t, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{current, previous.Public()}})
+		_, err := jwk.ParseSigningKeys(public, "current")
+		assert.ErrorContains(t, err, "not a supported private signing key")
+	})
+
+	t.Run("empty set", func(t *testing.T) {
+		_, err := jwk.ParseSigningKeys([]byte(`{"keys":[]}`), "")
+		assert.ErrorContains(t, err, "no keys")
+	})
+}
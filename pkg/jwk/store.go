@@ -0,0 +1,150 @@
+package jwk
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-jose/go-jose/v4"
+
+	"github.com/nais/jwker/pkg/filewatch"
+)
+
+// SigningKeyStore holds jwker's current signing keys. Keys loaded from a file are replaced atomically when the file changes,
+// so that every user of the store signs with the new active key without a restart.
+type SigningKeyStore struct {
+	path        string
+	activeKeyID string
+	// onReload, if set, is called with the outcome of every reload from the file.
+	onReload func(*SigningKeys, error)
+
+	current   atomic.Pointer[SigningKeys]
+	reloading sync.Mutex
+}
+
+// NewSigningKeyStore returns a store with fixed keys.
+func NewSigningKeyStore(keys *SigningKeys) *SigningKeyStore {
+	s := &SigningKeyStore{}
+	s.current.Store(keys)
+	return s
+}
+
+// LoadSigningKeyStore returns a store with the keys in the file at path; see ParseSigningKeys.
+// The keys are reloaded when the file changes while the store is started. onReload, if set, is called with the outcome of every reload.
+func LoadSigningKeyStore(path, activeKeyID string, onReload func(*SigningKeys, error)) (*SigningKeyStore, error) {
+	s := &SigningKeyStore{path: path, activeKeyID: activeKeyID, onReload: onReload}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Keys returns the current keys, or nil if there are none.
+func (s *SigningKeyStore) Keys() *SigningKeys {
+	if s == nil {
+		return nil
+	}
+	return s.current.Load()
+}
+
+// Active returns the current active key, or nil if there is none.
+func (s *SigningKeyStore) Active() *jose.JSONWebKey {
+	keys := s.Keys()
+	if keys == nil {
+		return nil
+	}
+	return &keys.Active
+}
+
+// Path returns the file the keys are loaded from, or an empty string for fixed keys.
+func (s *SigningKeyStore) Path() string {
+	return s.path
+}
+
+// Reload reads the keys from the file again. The current keys are only replaced if every new key is valid
+// and the new active key can sign; otherwise they are kept, and the error is returned.
+// It reports whether the active key or set of keys changed.
+func (s *SigningKeyStore) Reload() (bool, error) {
+	if s.path == "" {
+		return false, nil
+	}
+
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	previous := s.current.Load()
+	err := s.load()
+	if s.onReload != nil {
+		s.onReload(s.current.Load(), err)
+	}
+	if err != nil {
+		return false, err
+	}
+	return !sameKeys(previous, s.current.Load()), nil
+}
+
+func (s *SigningKeyStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("reading signing keys from %s: %w", s.path, err)
+	}
+
+	keys, err := ParseSigningKeys(data, s.activeKeyID)
+	if err != nil {
+		return fmt.Errorf("parsing signing keys from %s: %w", s.path, err)
+	}
+	if _, err := NewSigner(&keys.Active); err != nil {
+		return fmt.Errorf("creating signer for key %q from %s: %w", keys.Active.KeyID, s.path, err)
+	}
+
+	previous := s.current.Load()
+	if previous != nil && sameKeys(previous, keys) {
+		return nil
+	}
+	s.current.Store(keys)
+	return nil
+}
+
+// Start reloads the keys whenever the file changes, until ctx is done. It implements manager.Runnable.
+func (s *SigningKeyStore) Start(ctx context.Context) error {
+	if s.path == "" {
+		return nil
+	}
+	return filewatch.Watch(ctx, s.path, func() {
+		// failures are reported through onReload, and the current keys are kept
+		_, _ = s.Reload()
+	})
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every replica signs with the keys, e.g. in readiness probes.
+func (s *SigningKeyStore) NeedLeaderElection() bool {
+	return false
+}
+
+// sameKeys reports whether a and b have the same active key and the same keys, compared by thumbprint.
+func sameKeys(a, b *SigningKeys) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if len(a.Keys.Keys) != len(b.Keys.Keys) || !sameKey(a.Active, b.Active) {
+		return false
+	}
+	for i := range a.Keys.Keys {
+		if !sameKey(a.Keys.Keys[i], b.Keys.Keys[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameKey(a, b jose.JSONWebKey) bool {
+	if a.KeyID != b.KeyID || a.Algorithm != b.Algorithm {
+		return false
+	}
+	ta, errA := a.Thumbprint(crypto.SHA256)
+	tb, errB := b.Thumbprint(crypto.SHA256)
+	return errA == nil && errB == nil && string(ta) == string(tb)
+}
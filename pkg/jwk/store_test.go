@@ -0,0 +1,116 @@
+package jwk_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nais/jwker/pkg/jwk"
+)
+
+func TestSigningKeyStore(t *testing.T) {
+	writeKey := func(t *testing.T, path, keyID string) {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+		require.NoError(t, err)
+		data, err := json.Marshal(jose.JSONWebKey{Key: privateKey, KeyID: keyID, Use: "sig"})
+		require.NoError(t, err)
+
+		// like Kubernetes, replace the file instead of writing to it
+		next := path + ".next"
+		require.NoError(t, os.WriteFile(next, data, 0o600))
+		require.NoError(t, os.Rename(next, path))
+	}
+
+	t.Run("reload replaces valid keys and keeps the current keys on failure", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jwk.json")
+		writeKey(t, path, "first")
+
+		var successes, failures atomic.Int32
+		store, err := jwk.LoadSigningKeyStore(path, "", func(keys *jwk.SigningKeys, err error) {
+			if err != nil {
+				failures.Add(1)
+				return
+			}
+			successes.Add(1)
+		})
+		require.NoError(t, err)
+		first := store.Active()
+		assert.Equal(t, "first", first.KeyID)
+
+		changed, err := store.Reload()
+		assert.NoError(t, err)
+		assert.False(t, changed)
+		assert.Same(t, first, store.Active())
+
+		writeKey(t, path, "second")
+		changed, err = store.Reload()
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, "second", store.Active().KeyID)
+
+		require.NoError(t, os.WriteFile(path, []byte(`{"kty":"oct","k":"c2VjcmV0"}`), 0o600))
+		_, err = store.Reload()
+		assert.Error(t, err)
+		assert.Equal(t, "second", store.Active().KeyID)
+
+		assert.Equal(t, int32(2), successes.Load())
+		assert.Equal(t, int32(1), failures.Load())
+	})
+
+	t.Run("invalid key at startup", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jwk.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{}`), 0o600))
+
+		_, err := jwk.LoadSigningKeyStore(path, "", nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("fixed keys are never reloaded", func(t *testing.T) {
+		key, err := jwk.Generate()
+		require.NoError(t, err)
+		store := jwk.NewSigningKeyStore(&jwk.SigningKeys{Active: key})
+
+		changed, err := store.Reload()
+		assert.NoError(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, key.KeyID, store.Active().KeyID)
+		assert.NoError(t, store.Start(context.Background()))
+	})
+
+	t.Run("changed file is reloaded while started", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jwk.json")
+		writeKey(t, path, "first")
+
+		store, err := jwk.LoadSigningKeyStore(path, "", nil)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- store.Start(ctx) }()
+
+		assert.EventuallyWithT(t, func(c *assert.CollectT) {
+			writeKey(t, path, "second")
+			assert.Equal(c, "second", store.Active().KeyID)
+		}, 5*time.Second, 50*time.Millisecond)
+
+		cancel()
+		assert.NoError(t, <-done)
+	})
+}
+
+func TestNilSigningKeyStore(t *testing.T) {
+	var store *jwk.SigningKeyStore
+	assert.Nil(t, store.Keys())
+	assert.Nil(t, store.Active())
+}
@@ -5,7 +5,9 @@ import (
 	"log/slog"
 	"time"
 
+	"github.com/nais/jwker/pkg/resync"
 	"github.com/nais/jwker/pkg/secret"
+	"github.com/nais/jwker/pkg/status"
 	jwkerv1 "github.com/nais/liberator/pkg/apis/nais.io/v1"
 	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
@@ -41,11 +43,120 @@ var (
 			Help: "Number of jwkers that failed to process",
 		},
 	)
+	JwkersResyncedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "jwker_resynced_count",
+			Help: "Number of jwkers re-registered with Tokendings by the periodic resync",
+		},
+	)
+	JwkersResyncPending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "jwker_resync_pending",
+			Help: "Number of jwkers that are due for a periodic resync",
+		},
+	)
+	TokendingsDecommissionedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jwker_tokendings_decommissioned_count",
+			Help: "Number of clients deleted from each decommissioned Tokendings instance",
+		},
+		[]string{"instance"},
+	)
+	TokendingsDecommissionPending = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "jwker_tokendings_decommission_pending",
+			Help: "Number of jwkers with clients that remain to be deleted from each decommissioned Tokendings instance",
+		},
+		[]string{"instance"},
+	)
+	TokendingsDriftRepairedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jwker_tokendings_drift_repaired_count",
+			Help: "Number of clients found to have drifted from their jwker, and repaired, in each Tokendings instance",
+		},
+		[]string{"instance"},
+	)
+	TokendingsRegistrationsSkippedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jwker_tokendings_registrations_skipped_count",
+			Help: "Number of registrations skipped as the client was already registered unchanged in each Tokendings instance",
+		},
+		[]string{"instance"},
+	)
+	ClientJwkReloadCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jwker_client_jwk_reload_count",
+			Help: "Number of reloads of jwker's signing keys from file, by result",
+		},
+		[]string{"result"},
+	)
+	ClientJwkLastReloadSuccessTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "jwker_client_jwk_last_reload_success_timestamp_seconds",
+			Help: "Unix time of the last successful reload of jwker's signing keys from file",
+		},
+	)
+	DryRunChangesCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jwker_dry_run_changes_count",
+			Help: "Number of changes to Kubernetes and Tokendings that were not made in dry-run mode, by kind and action",
+		},
+		[]string{"kind", "action"},
+	)
+	TokendingsAuthTokenExpirySeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "jwker_tokendings_auth_token_expiry_seconds",
+			Help: "Seconds until the cached service account token used to authenticate to Tokendings expires; negative once expired",
+		},
+		[]string{"path"},
+	)
+	TokendingsCircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "jwker_tokendings_circuit_breaker_state",
+			Help: "State of the circuit breaker for each Tokendings instance; 0 is closed, 1 is half-open and 2 is open",
+		},
+		[]string{"instance"},
+	)
+	TokendingsHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "jwker_tokendings_healthy",
+			Help: "Whether the latest readiness probe of each Tokendings instance succeeded; 1 if healthy, 0 if not",
+		},
+		[]string{"instance"},
+	)
+	TokendingsPrimaryChangedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jwker_tokendings_primary_changed_count",
+			Help: "Number of times the primary Tokendings instance in a jwker secret has changed",
+		},
+		[]string{"from", "to"},
+	)
+	TokendingsMetadataChangedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jwker_tokendings_metadata_changed_count",
+			Help: "Number of times a refresh found changed authorization server metadata for each Tokendings instance",
+		},
+		[]string{"instance"},
+	)
+	TokendingsMetadataResolved = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "jwker_tokendings_metadata_resolved",
+			Help: "Whether the authorization server metadata of each Tokendings instance has been resolved; 1 if resolved, 0 if not",
+		},
+		[]string{"instance"},
+	)
+	TokendingsMetadataSecretsUpdatedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jwker_tokendings_metadata_secrets_updated_count",
+			Help: "Number of secrets updated with changed authorization server metadata for each Tokendings instance",
+		},
+		[]string{"instance"},
+	)
 
 	ctx = context.Background()
 )
 
-func RefreshTotalJwkerClusterMetrics(cli client.Client) error {
+func RefreshTotalJwkerClusterMetrics(cli client.Client, resyncPeriod time.Duration, decommissioned []string) error {
 	var err error
 	exp := 10 * time.Second
 
@@ -67,6 +178,27 @@ func RefreshTotalJwkerClusterMetrics(cli client.Client) error {
 			return err
 		}
 		JwkersTotal.Set(float64(len(jwkerList.Items)))
+
+		pending := 0
+		now := time.Now()
+		for _, jwker := range jwkerList.Items {
+			if due, _ := resync.Due(now, jwker.Status.SynchronizationTimestamp.Time, resyncPeriod, jwker.GetUID()); due {
+				pending++
+			}
+		}
+		JwkersResyncPending.Set(float64(pending))
+
+		for _, baseURL := range decommissioned {
+			remaining := 0
+			for _, jwker := range jwkerList.Items {
+				instances, err := status.Instances(&jwker)
+				// without an entry for the instance, the client may have been registered there before jwker recorded per-instance status
+				if err != nil || !status.Decommissioned(instances, baseURL) {
+					remaining++
+				}
+			}
+			TokendingsDecommissionPending.WithLabelValues(baseURL).Set(float64(remaining))
+		}
 	}
 	return nil
 }
@@ -0,0 +1,41 @@
+package resync
+
+import (
+	"hash/fnv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Next returns when an object last synchronized at lastSync is due for its next resync.
+//
+// Every object gets a stable offset within the period derived from its UID, and is resynced at that offset
+// in the first period that starts at least half a period after lastSync. This spreads resyncs evenly across the
+// period, also when many objects become due at once, e.g. after jwker has been down for a while.
+// The interval between two resyncs of the same object is between one half and one and a half periods.
+func Next(lastSync time.Time, period time.Duration, uid types.UID) time.Time {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(uid))
+	offset := time.Duration(h.Sum64() % uint64(period))
+
+	earliest := lastSync.Add(period / 2)
+	next := earliest.Truncate(period).Add(offset)
+	if next.Before(earliest) {
+		next = next.Add(period)
+	}
+	return next
+}
+
+// Due reports whether the object is due for a resync at now, and otherwise how long until it is.
+// A non-positive period disables resyncs.
+func Due(now, lastSync time.Time, period time.Duration, uid types.UID) (bool, time.Duration) {
+	if period <= 0 {
+		return false, 0
+	}
+
+	next := Next(lastSync, period, uid)
+	if !now.Before(next) {
+		return true, 0
+	}
+	return false, next.Sub(now)
+}
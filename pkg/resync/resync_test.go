@@ -0,0 +1,46 @@
+package resync
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNext(t *testing.T) {
+	period := time.Hour
+	lastSync := time.Date(2025, 1, 1, 12, 34, 56, 0, time.UTC)
+
+	offsets := make(map[time.Duration]bool)
+	for i := range 100 {
+		uid := types.UID(fmt.Sprintf("uid-%d", i))
+		next := Next(lastSync, period, uid)
+
+		assert.False(t, next.Before(lastSync.Add(period/2)), "resync too early for %s", uid)
+		assert.True(t, next.Before(lastSync.Add(period*3/2)), "resync too late for %s", uid)
+		assert.Equal(t, next, Next(lastSync, period, uid), "resync time should be stable for %s", uid)
+
+		offsets[next.Sub(next.Truncate(period))] = true
+	}
+
+	assert.Greater(t, len(offsets), 90, "resyncs should be spread across the period")
+}
+
+func TestDue(t *testing.T) {
+	uid := types.UID("some-uid")
+	lastSync := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	next := Next(lastSync, time.Hour, uid)
+
+	due, after := Due(next.Add(-time.Minute), lastSync, time.Hour, uid)
+	assert.False(t, due)
+	assert.Equal(t, time.Minute, after)
+
+	due, _ = Due(next, lastSync, time.Hour, uid)
+	assert.True(t, due)
+
+	due, after = Due(next, lastSync, 0, uid)
+	assert.False(t, due)
+	assert.Zero(t, after)
+}
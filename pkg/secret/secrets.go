@@ -8,12 +8,14 @@ import (
 	"github.com/go-jose/go-jose/v4"
 	"github.com/nais/jwker/pkg/tokendings"
 	"github.com/nais/liberator/pkg/kubernetes"
+	"github.com/nais/liberator/pkg/oauth"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
 	TokenXClientIDKey      = "TOKEN_X_CLIENT_ID"
+	TokenXInstancesKey     = "TOKEN_X_INSTANCES"
 	TokenXIssuerKey        = "TOKEN_X_ISSUER"
 	TokenXJwksURIKey       = "TOKEN_X_JWKS_URI"
 	TokenXPrivateJWKKey    = "TOKEN_X_PRIVATE_JWK"
@@ -23,15 +25,45 @@ const (
 	TokenXSecretLabelKey  = "type"
 	TokenXSecretLabelType = "jwker.nais.io"
 
+	// RegistrationAccessTokensKey holds the RFC 7591 registration access tokens of a client, keyed by instance base URL.
+	RegistrationAccessTokensKey = "REGISTRATION_ACCESS_TOKENS"
+	// RegistrationSecretLabelType differs from TokenXSecretLabelType, so that registration secrets are not mistaken for an app's secrets.
+	RegistrationSecretLabelType = "jwker.nais.io-registration"
+	registrationSecretPrefix    = "jwker-registration-"
+
 	StakaterReloaderAnnotationKey = "reloader.stakater.com/match"
 )
 
 var ErrNotFound = fmt.Errorf("not found")
 
 type Data struct {
-	ClientID   tokendings.ClientID
-	Jwk        jose.JSONWebKey
+	ClientID tokendings.ClientID
+	Jwk      jose.JSONWebKey
+	// Tokendings is the primary instance, which the TOKEN_X_* keys are populated from.
 	Tokendings tokendings.Instance
+	// Instances are all configured instances, including the primary.
+	Instances []tokendings.Instance
+	// Registrations holds the state assigned by instances that assign their own client IDs, keyed by base URL.
+	Registrations map[string]tokendings.RegistrationState
+}
+
+// clientID returns the client ID of the app at the instance at baseURL: the ID assigned by the instance if any, or the app's own.
+func (d Data) clientID(baseURL string) string {
+	if state, ok := d.Registrations[baseURL]; ok && state.ClientID != "" {
+		return state.ClientID
+	}
+	return d.ClientID.String()
+}
+
+// InstanceMetadata describes a single Tokendings instance in the TOKEN_X_INSTANCES key.
+type InstanceMetadata struct {
+	Issuer        string `json:"issuer"`
+	JwksURI       string `json:"jwks_uri"`
+	TokenEndpoint string `json:"token_endpoint"`
+	WellKnownURL  string `json:"well_known_url"`
+	Primary       bool   `json:"primary"`
+	// ClientID is set if the instance assigned the app a client ID other than TOKEN_X_CLIENT_ID.
+	ClientID string `json:"client_id,omitempty"`
 }
 
 func ExtractJWK(sec corev1.Secret) (jose.JSONWebKey, error) {
@@ -82,11 +114,21 @@ func CreateSecretSpec(secretName string, data Data) (*corev1.Secret, error) {
 		return nil, fmt.Errorf("marshalling private JWK: %w", err)
 	}
 
-	wellKnownURL, err := data.Tokendings.Metadata.WellKnownURL()
+	metadata := data.Tokendings.Metadata.Get()
+	if metadata == nil {
+		return nil, fmt.Errorf("missing metadata for tokendings instance %q", data.Tokendings.BaseURL)
+	}
+
+	wellKnownURL, err := metadata.WellKnownURL()
 	if err != nil {
 		return nil, fmt.Errorf("constructing well-known URL: %w", err)
 	}
 
+	instances, err := instancesJson(data)
+	if err != nil {
+		return nil, err
+	}
+
 	return &corev1.Secret{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Secret",
@@ -102,16 +144,167 @@ func CreateSecretSpec(secretName string, data Data) (*corev1.Secret, error) {
 		},
 		StringData: map[string]string{
 			TokenXPrivateJWKKey:    string(jwkJson),
-			TokenXClientIDKey:      data.ClientID.String(),
+			TokenXClientIDKey:      data.clientID(data.Tokendings.BaseURL),
 			TokenXWellKnownURLKey:  wellKnownURL,
-			TokenXIssuerKey:        data.Tokendings.Metadata.Issuer,
-			TokenXJwksURIKey:       data.Tokendings.Metadata.JwksURI,
-			TokenXTokenEndpointKey: data.Tokendings.Metadata.TokenEndpoint,
+			TokenXIssuerKey:        metadata.Issuer,
+			TokenXJwksURIKey:       metadata.JwksURI,
+			TokenXTokenEndpointKey: metadata.TokenEndpoint,
+			TokenXInstancesKey:     instances,
+		},
+		Type: corev1.SecretTypeOpaque,
+	}, nil
+}
+
+// UpdateMetadata replaces the metadata of a Tokendings instance in an existing secret, leaving the keys untouched.
+// Only values that match previous are replaced. It reports whether the secret changed.
+func UpdateMetadata(sec *corev1.Secret, previous, current *oauth.MetadataOAuth) (bool, error) {
+	changed := false
+
+	if string(sec.Data[TokenXIssuerKey]) == previous.Issuer &&
+		string(sec.Data[TokenXJwksURIKey]) == previous.JwksURI &&
+		string(sec.Data[TokenXTokenEndpointKey]) == previous.TokenEndpoint {
+		wellKnownURL, err := current.WellKnownURL()
+		if err != nil {
+			return false, fmt.Errorf("constructing well-known URL: %w", err)
+		}
+
+		sec.Data[TokenXWellKnownURLKey] = []byte(wellKnownURL)
+		sec.Data[TokenXIssuerKey] = []byte(current.Issuer)
+		sec.Data[TokenXJwksURIKey] = []byte(current.JwksURI)
+		sec.Data[TokenXTokenEndpointKey] = []byte(current.TokenEndpoint)
+		changed = true
+	}
+
+	raw, ok := sec.Data[TokenXInstancesKey]
+	if !ok {
+		return changed, nil
+	}
+
+	instances := make([]InstanceMetadata, 0)
+	if err := json.Unmarshal(raw, &instances); err != nil {
+		return false, fmt.Errorf("unmarshalling %s: %w", TokenXInstancesKey, err)
+	}
+
+	instancesChanged := false
+	for i, instance := range instances {
+		if instance.Issuer != previous.Issuer || instance.JwksURI != previous.JwksURI || instance.TokenEndpoint != previous.TokenEndpoint {
+			continue
+		}
+
+		updated, err := newInstanceMetadata(current, instance.Primary)
+		if err != nil {
+			return false, err
+		}
+		updated.ClientID = instance.ClientID
+		instances[i] = updated
+		instancesChanged = true
+	}
+	if !instancesChanged {
+		return changed, nil
+	}
+
+	raw, err := json.Marshal(instances)
+	if err != nil {
+		return false, fmt.Errorf("marshalling %s: %w", TokenXInstancesKey, err)
+	}
+	sec.Data[TokenXInstancesKey] = raw
+	return true, nil
+}
+
+// instancesJson returns the metadata of every instance as a JSON list, in the order they are configured.
+// The primary instance is used if no instances are given.
+func instancesJson(data Data) (string, error) {
+	instances := data.Instances
+	if len(instances) == 0 {
+		instances = []tokendings.Instance{data.Tokendings}
+	}
+
+	metadata := make([]InstanceMetadata, 0, len(instances))
+	for _, instance := range instances {
+		current := instance.Metadata.Get()
+		if current == nil {
+			return "", fmt.Errorf("missing metadata for tokendings instance %q", instance.BaseURL)
+		}
+
+		m, err := newInstanceMetadata(current, instance.BaseURL == data.Tokendings.BaseURL)
+		if err != nil {
+			return "", fmt.Errorf("tokendings instance %q: %w", instance.BaseURL, err)
+		}
+		if clientID := data.clientID(instance.BaseURL); clientID != data.clientID(data.Tokendings.BaseURL) {
+			m.ClientID = clientID
+		}
+		metadata = append(metadata, m)
+	}
+
+	raw, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("marshalling tokendings instances: %w", err)
+	}
+	return string(raw), nil
+}
+
+func newInstanceMetadata(metadata *oauth.MetadataOAuth, primary bool) (InstanceMetadata, error) {
+	wellKnownURL, err := metadata.WellKnownURL()
+	if err != nil {
+		return InstanceMetadata{}, fmt.Errorf("constructing well-known URL: %w", err)
+	}
+
+	return InstanceMetadata{
+		Issuer:        metadata.Issuer,
+		JwksURI:       metadata.JwksURI,
+		TokenEndpoint: metadata.TokenEndpoint,
+		WellKnownURL:  wellKnownURL,
+		Primary:       primary,
+	}, nil
+}
+
+// RegistrationSecretName returns the name of the secret that holds the registration access tokens for the Jwker named jwkerName.
+func RegistrationSecretName(jwkerName string) string {
+	return registrationSecretPrefix + jwkerName
+}
+
+// CreateRegistrationSecretSpec returns a secret holding the registration access tokens of a client, keyed by instance base URL.
+func CreateRegistrationSecretSpec(clientID tokendings.ClientID, tokens map[string]string) (*corev1.Secret, error) {
+	raw, err := json.Marshal(tokens)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling registration access tokens: %w", err)
+	}
+
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      RegistrationSecretName(clientID.Name),
+			Namespace: clientID.Namespace,
+			Labels: map[string]string{
+				"app":                clientID.Name,
+				TokenXSecretLabelKey: RegistrationSecretLabelType,
+			},
+		},
+		StringData: map[string]string{
+			RegistrationAccessTokensKey: string(raw),
 		},
 		Type: corev1.SecretTypeOpaque,
 	}, nil
 }
 
+// ExtractRegistrationAccessTokens returns the registration access tokens in a secret created by CreateRegistrationSecretSpec.
+func ExtractRegistrationAccessTokens(sec corev1.Secret) (map[string]string, error) {
+	tokens := make(map[string]string)
+
+	raw, ok := sec.Data[RegistrationAccessTokensKey]
+	if !ok {
+		return tokens, nil
+	}
+
+	if err := json.Unmarshal(raw, &tokens); err != nil {
+		return nil, fmt.Errorf("unmarshalling %s from secret %q: %w", RegistrationAccessTokensKey, sec.Name, err)
+	}
+	return tokens, nil
+}
+
 func Labels(appName string) map[string]string {
 	return map[string]string{
 		"app":                appName,
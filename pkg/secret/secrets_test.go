@@ -58,11 +58,11 @@ func TestCreateSecretSpec(t *testing.T) {
 		Jwk:      jwk,
 		Tokendings: tokendings.Instance{
 			BaseURL: "https://tokendings.example.com",
-			Metadata: &oauth.MetadataOAuth{
+			Metadata: tokendings.NewMetadata("", &oauth.MetadataOAuth{
 				Issuer:        "https://tokendings.example.com",
 				JwksURI:       "https://tokendings.example.com/jwks",
 				TokenEndpoint: "https://tokendings.example.com/token",
-			},
+			}),
 		},
 	}
 	actual, err := CreateSecretSpec(secretName, secretData)
@@ -79,6 +79,82 @@ func TestCreateSecretSpec(t *testing.T) {
 		assert.Equal(t, "https://tokendings.example.com/token", actual.StringData[TokenXTokenEndpointKey])
 	})
 
+	t.Run("should contain the primary instance if no instances are given", func(t *testing.T) {
+		var instances []InstanceMetadata
+		assert.NoError(t, json.Unmarshal([]byte(actual.StringData[TokenXInstancesKey]), &instances))
+		assert.Equal(t, []InstanceMetadata{
+			{
+				Issuer:        "https://tokendings.example.com",
+				JwksURI:       "https://tokendings.example.com/jwks",
+				TokenEndpoint: "https://tokendings.example.com/token",
+				WellKnownURL:  "https://tokendings.example.com/.well-known/oauth-authorization-server",
+				Primary:       true,
+			},
+		}, instances)
+	})
+
+	t.Run("should contain metadata for all instances", func(t *testing.T) {
+		secondary := tokendings.Instance{
+			BaseURL: "https://tokendings.other.example.com",
+			Metadata: tokendings.NewMetadata("", &oauth.MetadataOAuth{
+				Issuer:        "https://tokendings.other.example.com",
+				JwksURI:       "https://tokendings.other.example.com/jwks",
+				TokenEndpoint: "https://tokendings.other.example.com/token",
+			}),
+		}
+		data := secretData
+		data.Instances = []tokendings.Instance{secondary, secretData.Tokendings}
+
+		actual, err := CreateSecretSpec(secretName, data)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://tokendings.example.com", actual.StringData[TokenXIssuerKey])
+
+		var instances []InstanceMetadata
+		assert.NoError(t, json.Unmarshal([]byte(actual.StringData[TokenXInstancesKey]), &instances))
+		assert.Len(t, instances, 2)
+		assert.Equal(t, "https://tokendings.other.example.com", instances[0].Issuer)
+		assert.Equal(t, "https://tokendings.other.example.com/.well-known/oauth-authorization-server", instances[0].WellKnownURL)
+		assert.False(t, instances[0].Primary)
+		assert.Equal(t, "https://tokendings.example.com", instances[1].Issuer)
+		assert.True(t, instances[1].Primary)
+	})
+
+	t.Run("should contain client IDs assigned by instances", func(t *testing.T) {
+		secondary := tokendings.Instance{
+			BaseURL: "https://dcr.example.com",
+			Metadata: tokendings.NewMetadata("", &oauth.MetadataOAuth{
+				Issuer:        "https://dcr.example.com",
+				JwksURI:       "https://dcr.example.com/jwks",
+				TokenEndpoint: "https://dcr.example.com/token",
+			}),
+		}
+		data := secretData
+		data.Instances = []tokendings.Instance{secretData.Tokendings, secondary}
+		data.Registrations = map[string]tokendings.RegistrationState{
+			"https://dcr.example.com": {ClientID: "assigned-client-id"},
+		}
+
+		actual, err := CreateSecretSpec(secretName, data)
+		assert.NoError(t, err)
+		assert.Equal(t, app.String(), actual.StringData[TokenXClientIDKey])
+
+		var instances []InstanceMetadata
+		assert.NoError(t, json.Unmarshal([]byte(actual.StringData[TokenXInstancesKey]), &instances))
+		assert.Len(t, instances, 2)
+		assert.Empty(t, instances[0].ClientID)
+		assert.Equal(t, "assigned-client-id", instances[1].ClientID)
+
+		data.Tokendings = secondary
+		actual, err = CreateSecretSpec(secretName, data)
+		assert.NoError(t, err)
+		assert.Equal(t, "assigned-client-id", actual.StringData[TokenXClientIDKey])
+
+		instances = nil
+		assert.NoError(t, json.Unmarshal([]byte(actual.StringData[TokenXInstancesKey]), &instances))
+		assert.Equal(t, app.String(), instances[0].ClientID)
+		assert.Empty(t, instances[1].ClientID)
+	})
+
 	t.Run("should contain expected metadata", func(t *testing.T) {
 		expectedLabels := map[string]string{
 			"app":                app.Name,
@@ -92,6 +168,114 @@ func TestCreateSecretSpec(t *testing.T) {
 	})
 }
 
+func TestRegistrationSecret(t *testing.T) {
+	app := tokendings.ClientID{Name: "app", Namespace: "team", Cluster: "cluster"}
+	tokens := map[string]string{"https://dcr.example.com": "registration-access-token"}
+
+	spec, err := CreateRegistrationSecretSpec(app, tokens)
+	assert.NoError(t, err)
+	assert.Equal(t, "jwker-registration-app", spec.GetName())
+	assert.Equal(t, "team", spec.GetNamespace())
+	assert.Equal(t, RegistrationSecretLabelType, spec.GetLabels()[TokenXSecretLabelKey])
+
+	sec := corev1.Secret{Data: map[string][]byte{
+		RegistrationAccessTokensKey: []byte(spec.StringData[RegistrationAccessTokensKey]),
+	}}
+	extracted, err := ExtractRegistrationAccessTokens(sec)
+	assert.NoError(t, err)
+	assert.Equal(t, tokens, extracted)
+
+	extracted, err = ExtractRegistrationAccessTokens(corev1.Secret{})
+	assert.NoError(t, err)
+	assert.Empty(t, extracted)
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	previous := &oauth.MetadataOAuth{
+		Issuer:        "https://tokendings.example.com",
+		JwksURI:       "https://tokendings.example.com/jwks",
+		TokenEndpoint: "https://tokendings.example.com/token",
+	}
+	current := &oauth.MetadataOAuth{
+		Issuer:        "https://tokenx.example.com",
+		JwksURI:       "https://tokenx.example.com/jwks",
+		TokenEndpoint: "https://tokenx.example.com/token",
+	}
+	other := &oauth.MetadataOAuth{
+		Issuer:        "https://tokendings.other.example.com",
+		JwksURI:       "https://tokendings.other.example.com/jwks",
+		TokenEndpoint: "https://tokendings.other.example.com/token",
+	}
+
+	key, err := jwk.Generate()
+	assert.NoError(t, err)
+
+	toSecret := func(t *testing.T, primary *oauth.MetadataOAuth, instances ...*oauth.MetadataOAuth) *corev1.Secret {
+		data := Data{
+			ClientID:   tokendings.ClientID{Name: "test", Namespace: "test", Cluster: "test"},
+			Jwk:        key,
+			Tokendings: tokendings.Instance{BaseURL: primary.Issuer, Metadata: tokendings.NewMetadata("", primary)},
+		}
+		for _, instance := range instances {
+			data.Instances = append(data.Instances, tokendings.Instance{BaseURL: instance.Issuer, Metadata: tokendings.NewMetadata("", instance)})
+		}
+
+		spec, err := CreateSecretSpec("test-secret", data)
+		assert.NoError(t, err)
+
+		sec := &corev1.Secret{Data: make(map[string][]byte)}
+		for k, v := range spec.StringData {
+			sec.Data[k] = []byte(v)
+		}
+		return sec
+	}
+
+	t.Run("should update the primary instance", func(t *testing.T) {
+		sec := toSecret(t, previous, previous, other)
+		jwkBefore := string(sec.Data[TokenXPrivateJWKKey])
+
+		changed, err := UpdateMetadata(sec, previous, current)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+
+		assert.Equal(t, jwkBefore, string(sec.Data[TokenXPrivateJWKKey]))
+		assert.Equal(t, "https://tokenx.example.com/.well-known/oauth-authorization-server", string(sec.Data[TokenXWellKnownURLKey]))
+		assert.Equal(t, "https://tokenx.example.com", string(sec.Data[TokenXIssuerKey]))
+		assert.Equal(t, "https://tokenx.example.com/jwks", string(sec.Data[TokenXJwksURIKey]))
+		assert.Equal(t, "https://tokenx.example.com/token", string(sec.Data[TokenXTokenEndpointKey]))
+
+		var instances []InstanceMetadata
+		assert.NoError(t, json.Unmarshal(sec.Data[TokenXInstancesKey], &instances))
+		assert.Equal(t, "https://tokenx.example.com", instances[0].Issuer)
+		assert.True(t, instances[0].Primary)
+		assert.Equal(t, "https://tokendings.other.example.com", instances[1].Issuer)
+	})
+
+	t.Run("should update a secondary instance", func(t *testing.T) {
+		sec := toSecret(t, other, other, previous)
+
+		changed, err := UpdateMetadata(sec, previous, current)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, "https://tokendings.other.example.com", string(sec.Data[TokenXIssuerKey]))
+
+		var instances []InstanceMetadata
+		assert.NoError(t, json.Unmarshal(sec.Data[TokenXInstancesKey], &instances))
+		assert.Equal(t, "https://tokendings.other.example.com", instances[0].Issuer)
+		assert.Equal(t, "https://tokenx.example.com", instances[1].Issuer)
+		assert.False(t, instances[1].Primary)
+	})
+
+	t.Run("should leave secrets for other instances untouched", func(t *testing.T) {
+		sec := toSecret(t, other)
+
+		changed, err := UpdateMetadata(sec, previous, current)
+		assert.NoError(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, "https://tokendings.other.example.com", string(sec.Data[TokenXIssuerKey]))
+	})
+}
+
 func JsonAsString(v any) string {
 	j, err := json.MarshalIndent(v, "", " ")
 	if err != nil {
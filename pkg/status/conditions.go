@@ -0,0 +1,104 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConditionsAnnotationKey holds the conditions of a Jwker. Like the per-instance status, they are kept in an annotation,
+// as the Jwker status schema has no conditions.
+const ConditionsAnnotationKey = "jwker.nais.io/conditions"
+
+const (
+	// ConditionReady summarizes the other conditions. It is true when all of them are, and otherwise has the reason
+	// and message of the first one that is not.
+	ConditionReady = "Ready"
+	// ConditionRegistered is true when the client is registered with every configured Tokendings instance.
+	ConditionRegistered = "Registered"
+	// ConditionSecretSynced is true when the secret holds the client's current keys and Tokendings metadata.
+	ConditionSecretSynced = "SecretSynced"
+	// ConditionCleanupComplete is true when unused secrets and clients in decommissioned Tokendings instances have been deleted.
+	ConditionCleanupComplete = "CleanupComplete"
+)
+
+// summarizedConditions are the conditions that Ready summarizes, in order of precedence.
+var summarizedConditions = []string{ConditionSecretSynced, ConditionRegistered, ConditionCleanupComplete}
+
+// Conditions returns the conditions recorded on obj, or an empty slice if none are recorded.
+func Conditions(obj metav1.Object) ([]metav1.Condition, error) {
+	conditions := make([]metav1.Condition, 0)
+
+	raw, ok := obj.GetAnnotations()[ConditionsAnnotationKey]
+	if !ok || raw == "" {
+		return conditions, nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &conditions); err != nil {
+		return nil, fmt.Errorf("unmarshalling annotation %q: %w", ConditionsAnnotationKey, err)
+	}
+	return conditions, nil
+}
+
+// SetConditions records the conditions on obj. It reports whether the annotation changed.
+func SetConditions(obj metav1.Object, conditions []metav1.Condition) (bool, error) {
+	raw, err := json.Marshal(conditions)
+	if err != nil {
+		return false, fmt.Errorf("marshalling annotation %q: %w", ConditionsAnnotationKey, err)
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	if annotations[ConditionsAnnotationKey] == string(raw) {
+		return false, nil
+	}
+
+	annotations[ConditionsAnnotationKey] = string(raw)
+	obj.SetAnnotations(annotations)
+	return true, nil
+}
+
+// MergeConditions returns existing with the updated conditions set, and Ready set to summarize them for generation.
+// The transition time of a condition is only changed when its status changes; see meta.SetStatusCondition.
+func MergeConditions(existing []metav1.Condition, generation int64, updated ...metav1.Condition) []metav1.Condition {
+	merged := make([]metav1.Condition, len(existing))
+	copy(merged, existing)
+
+	for _, condition := range updated {
+		condition.ObservedGeneration = generation
+		meta.SetStatusCondition(&merged, condition)
+	}
+
+	ready := metav1.Condition{
+		Type:               ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             "Synchronized",
+		Message:            "the client is registered with every Tokendings instance, and the secret is up to date",
+	}
+	for _, conditionType := range summarizedConditions {
+		condition := meta.FindStatusCondition(merged, conditionType)
+		if condition == nil && conditionType == ConditionCleanupComplete {
+			// nothing has needed cleaning up
+			continue
+		}
+		if condition == nil {
+			ready.Status = metav1.ConditionUnknown
+			ready.Reason = "Pending"
+			ready.Message = fmt.Sprintf("condition %s has not been determined yet", conditionType)
+			break
+		}
+		if condition.Status != metav1.ConditionTrue {
+			ready.Status = metav1.ConditionFalse
+			ready.Reason = condition.Reason
+			ready.Message = condition.Message
+			break
+		}
+	}
+	meta.SetStatusCondition(&merged, ready)
+	return merged
+}
@@ -0,0 +1,69 @@
+package status
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetConditions(t *testing.T) {
+	obj := &metav1.ObjectMeta{}
+
+	conditions, err := Conditions(obj)
+	require.NoError(t, err)
+	assert.Empty(t, conditions)
+
+	expected := MergeConditions(conditions, 1, metav1.Condition{Type: ConditionRegistered, Status: metav1.ConditionTrue, Reason: "Registered"})
+	changed, err := SetConditions(obj, expected)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = SetConditions(obj, expected)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	conditions, err = Conditions(obj)
+	require.NoError(t, err)
+	assert.Len(t, conditions, 2)
+	assert.True(t, meta.IsStatusConditionTrue(conditions, ConditionRegistered))
+}
+
+func TestMergeConditions(t *testing.T) {
+	registered := metav1.Condition{Type: ConditionRegistered, Status: metav1.ConditionTrue, Reason: "Registered"}
+	synced := metav1.Condition{Type: ConditionSecretSynced, Status: metav1.ConditionTrue, Reason: "SecretWritten"}
+	failed := metav1.Condition{Type: ConditionRegistered, Status: metav1.ConditionFalse, Reason: "RegistrationFailed", Message: "https://a: 400 Bad Request: invalid software statement"}
+
+	t.Run("ready is pending until every condition is known", func(t *testing.T) {
+		conditions := MergeConditions(nil, 1, registered)
+		ready := meta.FindStatusCondition(conditions, ConditionReady)
+		require.NotNil(t, ready)
+		assert.Equal(t, metav1.ConditionUnknown, ready.Status)
+		assert.Equal(t, int64(1), ready.ObservedGeneration)
+	})
+
+	t.Run("ready when every condition is true", func(t *testing.T) {
+		conditions := MergeConditions(nil, 1, registered, synced)
+		assert.True(t, meta.IsStatusConditionTrue(conditions, ConditionReady))
+	})
+
+	t.Run("not ready carries the reason and message of the failed condition", func(t *testing.T) {
+		conditions := MergeConditions(MergeConditions(nil, 1, registered, synced), 2, failed)
+		ready := meta.FindStatusCondition(conditions, ConditionReady)
+		require.NotNil(t, ready)
+		assert.Equal(t, metav1.ConditionFalse, ready.Status)
+		assert.Equal(t, failed.Reason, ready.Reason)
+		assert.Equal(t, failed.Message, ready.Message)
+		assert.Equal(t, int64(2), meta.FindStatusCondition(conditions, ConditionRegistered).ObservedGeneration)
+	})
+
+	t.Run("transition time is kept while the status is unchanged", func(t *testing.T) {
+		existing := MergeConditions(nil, 1, registered, synced)
+		existing[0].LastTransitionTime = metav1.Unix(0, 0)
+
+		conditions := MergeConditions(existing, 2, registered)
+		assert.Equal(t, metav1.Unix(0, 0), meta.FindStatusCondition(conditions, ConditionRegistered).LastTransitionTime)
+	})
+}
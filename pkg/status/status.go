@@ -0,0 +1,165 @@
+package status
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InstancesAnnotationKey holds the per-instance Tokendings status of a Jwker.
+// The Jwker status schema is owned by liberator, so jwker keeps its own bookkeeping in an annotation. As annotations are
+// not part of the status subresource, every change to it is a write of the whole Jwker; see MergeInstances.
+const InstancesAnnotationKey = "jwker.nais.io/tokendings-instances"
+
+type Instance struct {
+	BaseURL    string `json:"baseURL"`
+	Registered bool   `json:"registered"`
+	// Primary is set for the instance whose metadata is written to the secret.
+	Primary bool   `json:"primary,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// LastTransitionTime is when the entry last changed, other than by Primary.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+	// Registration holds the values Tokendings responded with for the latest registration it responded to, if any.
+	Registration *Registration `json:"registration,omitempty"`
+	// Fingerprint identifies the registration that the instance accepted in the latest attempt; see tokendings.Fingerprint.
+	// It is empty if the latest attempt failed.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Drift lists how the client had drifted from the Jwker when it was last resynced, if it had. The drift is repaired unless Error is set.
+	Drift []string `json:"drift,omitempty"`
+	// Decommissioned is set once the client has been deleted from the instance, after the instance was decommissioned.
+	Decommissioned bool `json:"decommissioned,omitempty"`
+}
+
+// Registration is the client as registered with a Tokendings instance.
+type Registration struct {
+	ClientName              string   `json:"clientName"`
+	KeyIDs                  []string `json:"keyIDs,omitempty"`
+	GrantTypes              []string `json:"grantTypes,omitempty"`
+	TokenEndpointAuthMethod string   `json:"tokenEndpointAuthMethod,omitempty"`
+	// ClientID and RegistrationClientURI are set by instances that assign their own client IDs, i.e. that speak RFC 7591.
+	// The registration access token is kept in a secret; see secret.RegistrationSecretName.
+	ClientID              string `json:"clientID,omitempty"`
+	RegistrationClientURI string `json:"registrationClientURI,omitempty"`
+}
+
+// Instances returns the per-instance status recorded on obj, or an empty slice if none is recorded.
+func Instances(obj metav1.Object) ([]Instance, error) {
+	instances := make([]Instance, 0)
+
+	raw, ok := obj.GetAnnotations()[InstancesAnnotationKey]
+	if !ok || raw == "" {
+		return instances, nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &instances); err != nil {
+		return nil, fmt.Errorf("unmarshalling annotation %q: %w", InstancesAnnotationKey, err)
+	}
+	return instances, nil
+}
+
+// SetInstances records the per-instance status on obj. It reports whether the annotation changed.
+func SetInstances(obj metav1.Object, instances []Instance) (bool, error) {
+	raw, err := json.Marshal(instances)
+	if err != nil {
+		return false, fmt.Errorf("marshalling annotation %q: %w", InstancesAnnotationKey, err)
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	if annotations[InstancesAnnotationKey] == string(raw) {
+		return false, nil
+	}
+
+	annotations[InstancesAnnotationKey] = string(raw)
+	obj.SetAnnotations(annotations)
+	return true, nil
+}
+
+// MergeInstances returns updated, followed by the entries in existing for instances that are not in updated.
+// Entries for instances that are no longer configured are kept, as the client may still be registered there.
+// An updated entry that records the same outcome as the existing one keeps its LastTransitionTime, so that an unchanged
+// outcome leaves the annotation unchanged.
+func MergeInstances(existing, updated []Instance) []Instance {
+	merged := make([]Instance, 0, len(existing)+len(updated))
+	for _, u := range updated {
+		if i := slices.IndexFunc(existing, func(e Instance) bool { return e.BaseURL == u.BaseURL }); i >= 0 && sameOutcome(existing[i], u) {
+			u.LastTransitionTime = existing[i].LastTransitionTime
+		}
+		merged = append(merged, u)
+	}
+
+	for _, e := range existing {
+		if !slices.ContainsFunc(updated, func(u Instance) bool { return u.BaseURL == e.BaseURL }) {
+			merged = append(merged, e)
+		}
+	}
+	return merged
+}
+
+// sameOutcome reports whether a and b are equal, apart from LastTransitionTime and Primary.
+// They are compared as recorded, where empty and missing lists are the same.
+func sameOutcome(a, b Instance) bool {
+	a.LastTransitionTime, b.LastTransitionTime = metav1.Time{}, metav1.Time{}
+	a.Primary, b.Primary = false, false
+
+	rawA, errA := json.Marshal(a)
+	rawB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(rawA, rawB)
+}
+
+// MissingRegistrations returns the base URLs in baseURLs that instances does not record a registration with.
+func MissingRegistrations(instances []Instance, baseURLs []string) []string {
+	missing := make([]string, 0)
+	for _, baseURL := range baseURLs {
+		if !slices.ContainsFunc(instances, func(i Instance) bool { return i.BaseURL == baseURL && i.Registered }) {
+			missing = append(missing, baseURL)
+		}
+	}
+	return missing
+}
+
+// Unchanged reports whether instances records that the instance at baseURL accepted the registration identified by fingerprint.
+func Unchanged(instances []Instance, baseURL, fingerprint string) bool {
+	return fingerprint != "" && slices.ContainsFunc(instances, func(i Instance) bool {
+		return i.BaseURL == baseURL && i.Registered && i.Fingerprint == fingerprint
+	})
+}
+
+// SetDecommissioned returns instances with the entries for the given base URLs replaced by entries that record that the client
+// has been deleted from them.
+func SetDecommissioned(instances []Instance, baseURLs []string) []Instance {
+	decommissioned := make([]Instance, len(baseURLs))
+	for i, baseURL := range baseURLs {
+		decommissioned[i] = Instance{BaseURL: baseURL, Decommissioned: true}
+	}
+	return MergeInstances(instances, decommissioned)
+}
+
+// Decommissioned reports whether instances records that the client has been deleted from the instance at baseURL.
+// Without an entry for the instance, the client may still be registered there, e.g. if it was registered before jwker
+// recorded per-instance status.
+func Decommissioned(instances []Instance, baseURL string) bool {
+	return slices.ContainsFunc(instances, func(i Instance) bool { return i.BaseURL == baseURL && i.Decommissioned })
+}
+
+// Primary returns the base URL of the instance recorded as primary, or an empty string if there is none.
+func Primary(instances []Instance) string {
+	for _, i := range instances {
+		if i.Primary {
+			return i.BaseURL
+		}
+	}
+	return ""
+}
+
+// SetPrimary marks the instance at baseURL as primary, and no other.
+func SetPrimary(instances []Instance, baseURL string) {
+	for i := range instances {
+		instances[i].Primary = instances[i].BaseURL == baseURL
+	}
+}
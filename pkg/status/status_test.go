@@ -0,0 +1,126 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetInstances(t *testing.T) {
+	obj := &metav1.ObjectMeta{}
+
+	instances, err := Instances(obj)
+	require.NoError(t, err)
+	assert.Empty(t, instances)
+
+	expected := []Instance{
+		{BaseURL: "https://a", Registered: true},
+		{BaseURL: "https://b", Error: "503 Service Unavailable"},
+	}
+	changed, err := SetInstances(obj, expected)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = SetInstances(obj, expected)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	instances, err = Instances(obj)
+	require.NoError(t, err)
+	assert.Equal(t, expected, instances)
+}
+
+func TestMergeInstances(t *testing.T) {
+	existing := []Instance{
+		{BaseURL: "https://old", Registered: true},
+		{BaseURL: "https://a", Registered: false},
+	}
+	updated := []Instance{
+		{BaseURL: "https://a", Registered: true},
+		{BaseURL: "https://b", Registered: true},
+	}
+
+	assert.Equal(t, []Instance{
+		{BaseURL: "https://a", Registered: true},
+		{BaseURL: "https://b", Registered: true},
+		{BaseURL: "https://old", Registered: true},
+	}, MergeInstances(existing, updated))
+}
+
+func TestMergeInstancesKeepsTransitionTimeOfUnchangedOutcome(t *testing.T) {
+	before := metav1.NewTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	now := metav1.NewTime(before.Add(time.Hour))
+
+	existing := []Instance{
+		{BaseURL: "https://a", Registered: true, Primary: true, LastTransitionTime: before, Registration: &Registration{ClientName: "app", GrantTypes: []string{}}},
+		{BaseURL: "https://b", Registered: true, LastTransitionTime: before},
+	}
+	updated := []Instance{
+		{BaseURL: "https://a", Registered: true, LastTransitionTime: now, Registration: &Registration{ClientName: "app"}},
+		{BaseURL: "https://b", Error: "503 Service Unavailable", LastTransitionTime: now},
+	}
+
+	merged := MergeInstances(existing, updated)
+	assert.Equal(t, before, merged[0].LastTransitionTime, "unchanged outcome should keep its transition time")
+	assert.Equal(t, now, merged[1].LastTransitionTime, "changed outcome should have a new transition time")
+}
+
+func TestMissingRegistrations(t *testing.T) {
+	instances := []Instance{
+		{BaseURL: "https://a", Registered: true},
+		{BaseURL: "https://b", Registered: false},
+	}
+
+	assert.Equal(t, []string{"https://b", "https://c"}, MissingRegistrations(instances, []string{"https://a", "https://b", "https://c"}))
+	assert.Empty(t, MissingRegistrations(instances, []string{"https://a"}))
+}
+
+func TestUnchanged(t *testing.T) {
+	instances := []Instance{
+		{BaseURL: "https://a", Registered: true, Fingerprint: "sha256:1"},
+		{BaseURL: "https://b", Registered: false},
+	}
+
+	assert.True(t, Unchanged(instances, "https://a", "sha256:1"))
+	assert.False(t, Unchanged(instances, "https://a", "sha256:2"))
+	assert.False(t, Unchanged(instances, "https://b", ""))
+	assert.False(t, Unchanged(instances, "https://c", "sha256:1"))
+}
+
+func TestSetDecommissioned(t *testing.T) {
+	instances := []Instance{
+		{BaseURL: "https://a", Registered: true},
+		{BaseURL: "https://old", Registered: true, Fingerprint: "sha256:1"},
+	}
+
+	updated := SetDecommissioned(instances, []string{"https://old", "https://older"})
+	assert.ElementsMatch(t, []Instance{
+		{BaseURL: "https://a", Registered: true},
+		{BaseURL: "https://old", Decommissioned: true},
+		{BaseURL: "https://older", Decommissioned: true},
+	}, updated)
+	assert.Len(t, instances, 2, "input should not be modified")
+
+	assert.True(t, Decommissioned(updated, "https://old"))
+	assert.True(t, Decommissioned(updated, "https://older"))
+	assert.False(t, Decommissioned(updated, "https://a"))
+	assert.False(t, Decommissioned(instances, "https://old"))
+	assert.False(t, Decommissioned(instances, "https://b"), "an instance without an entry may still have the client")
+}
+
+func TestPrimary(t *testing.T) {
+	instances := []Instance{
+		{BaseURL: "https://a", Primary: true},
+		{BaseURL: "https://b"},
+	}
+	assert.Equal(t, "https://a", Primary(instances))
+
+	SetPrimary(instances, "https://b")
+	assert.Equal(t, "https://b", Primary(instances))
+	assert.False(t, instances[0].Primary)
+
+	assert.Empty(t, Primary(nil))
+}
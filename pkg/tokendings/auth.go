@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-jose/go-jose/v4"
 	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/nais/jwker/pkg/jwk"
 )
 
 type CustomClaims struct {
@@ -18,35 +19,39 @@ type CustomClaims struct {
 	Audience  string          `json:"aud,omitempty"`
 }
 
-func Claims(clientid, audience string) CustomClaims {
-	now := time.Now()
-	exp := jwt.NewNumericDate(now.Add(time.Second * 50000000))
+// DefaultClientAssertionLifetime is the lifetime of client assertions unless configured otherwise.
+// RFC 7523 recommends short-lived assertions, as they are bearer credentials.
+const DefaultClientAssertionLifetime = 2 * time.Minute
 
+// clockSkewLeeway is how long before they are issued client assertions are valid, so that they are accepted by servers whose clocks are behind.
+const clockSkewLeeway = 30 * time.Second
+
+// Claims returns the claims of a client assertion issued at now, as described in RFC 7523, section 3.
+// The assertion expires lifetime after now, and is valid from clockSkewLeeway before now.
+func Claims(clientid, audience string, now time.Time, lifetime time.Duration) CustomClaims {
 	return CustomClaims{
 		Issuer:    clientid,
 		Subject:   clientid,
-		Expiry:    *exp,
-		NotBefore: 1,
+		Expiry:    *jwt.NewNumericDate(now.Add(lifetime)),
+		NotBefore: *jwt.NewNumericDate(now.Add(-clockSkewLeeway)),
+		IssuedAt:  *jwt.NewNumericDate(now),
 		ID:        cryptorand.Text(),
 		Audience:  audience,
 	}
 }
 
+// ClientAssertion signs a client assertion for endpoint with the default lifetime.
 func ClientAssertion(privateJwk *jose.JSONWebKey, clientID string, endpoint string) (string, error) {
-	key := jose.SigningKey{Algorithm: jose.RS256, Key: privateJwk.Key}
-
-	signerOpts := jose.SignerOptions{}
-	signerOpts.WithType("JWT")
-	signerOpts.WithHeader("kid", privateJwk.KeyID)
+	return signClaims(privateJwk, Claims(clientID, endpoint, time.Now(), DefaultClientAssertionLifetime))
+}
 
-	rsaSigner, err := jose.NewSigner(key, &signerOpts)
+func signClaims(privateJwk *jose.JSONWebKey, claims CustomClaims) (string, error) {
+	signer, err := jwk.NewSigner(privateJwk)
 	if err != nil {
 		return "", err
 	}
 
-	claims := Claims(clientID, endpoint)
-
-	builder := jwt.Signed(rsaSigner).Claims(claims)
+	builder := jwt.Signed(signer).Claims(claims)
 	rawJWT, err := builder.Serialize()
 	if err != nil {
 		return "", err
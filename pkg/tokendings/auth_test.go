@@ -1,11 +1,19 @@
 package tokendings
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/go-jose/go-jose/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/nais/jwker/pkg/jwk"
 )
@@ -28,4 +36,124 @@ func TestClientAssertion(t *testing.T) {
 	assert.Equal(t, "client1", claims.Issuer)
 	assert.Equal(t, "client1", claims.Subject)
 	assert.Equal(t, "http://endpoint/registration/client", claims.Audience)
+	assert.NotEmpty(t, claims.ID)
+	assert.NotZero(t, claims.IssuedAt)
+	assert.Equal(t, claims.IssuedAt.Time().Add(-clockSkewLeeway), claims.NotBefore.Time())
+	assert.Equal(t, claims.IssuedAt.Time().Add(DefaultClientAssertionLifetime), claims.Expiry.Time())
+}
+
+func TestClaims(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	claims := Claims("client1", "http://endpoint/registration/client", now, time.Minute)
+	assert.Equal(t, now, claims.IssuedAt.Time().UTC())
+	assert.Equal(t, now.Add(-30*time.Second), claims.NotBefore.Time().UTC(), "should be valid for servers with clocks that are behind")
+	assert.Equal(t, now.Add(time.Minute), claims.Expiry.Time().UTC(), "should expire the lifetime after it is issued")
+}
+
+func TestClientAssertionWithECKey(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	require.NoError(t, err)
+	key := jose.JSONWebKey{Key: privateKey, KeyID: "ec", Use: "sig"}
+
+	raw, err := ClientAssertion(&key, "client1", "http://endpoint/registration/client")
+	require.NoError(t, err)
+
+	sign, err := jose.ParseSignedCompact(raw, []jose.SignatureAlgorithm{jose.ES256})
+	require.NoError(t, err)
+	assert.Equal(t, "ec", sign.Signatures[0].Header.KeyID)
+	_, err = sign.Verify(key.Public())
+	assert.NoError(t, err)
+}
+
+func TestClientAssertionSigner(t *testing.T) {
+	key, err := jwk.Generate()
+	assert.NoError(t, err)
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	claimsOf := func(t *testing.T, raw string) CustomClaims {
+		sign, err := jose.ParseSignedCompact(raw, []jose.SignatureAlgorithm{jose.RS256})
+		require.NoError(t, err)
+		payload, err := sign.Verify(key.Public())
+		require.NoError(t, err)
+
+		claims := CustomClaims{}
+		require.NoError(t, json.Unmarshal(payload, &claims))
+		return claims
+	}
+
+	t.Run("assertions are cached per audience until near expiry", func(t *testing.T) {
+		signer := &ClientAssertionSigner{ClientID: "jwker", Keys: signingKeys(&key), Lifetime: 4 * time.Minute}
+		signer.now = func() time.Time { return now }
+
+		first, err := signer.Token(context.Background(), "http://a/registration/client")
+		require.NoError(t, err)
+		claims := claimsOf(t, first)
+		assert.Equal(t, now.Unix(), claims.IssuedAt.Time().Unix())
+		assert.Equal(t, now.Add(-clockSkewLeeway).Unix(), claims.NotBefore.Time().Unix())
+		assert.Equal(t, now.Add(4*time.Minute).Unix(), claims.Expiry.Time().Unix())
+
+		other, err := signer.Token(context.Background(), "http://b/registration/client")
+		require.NoError(t, err)
+		assert.NotEqual(t, first, other)
+		assert.Equal(t, "http://b/registration/client", claimsOf(t, other).Audience)
+
+		now = now.Add(3*time.Minute - time.Second)
+		cached, err := signer.Token(context.Background(), "http://a/registration/client")
+		require.NoError(t, err)
+		assert.Equal(t, first, cached)
+
+		now = now.Add(time.Second)
+		renewed, err := signer.Token(context.Background(), "http://a/registration/client")
+		require.NoError(t, err)
+		assert.NotEqual(t, first, renewed)
+		assert.NotEqual(t, claims.ID, claimsOf(t, renewed).ID)
+	})
+
+	t.Run("replay window limits reuse", func(t *testing.T) {
+		signer := &ClientAssertionSigner{ClientID: "jwker", Keys: signingKeys(&key), Lifetime: 4 * time.Minute, ReplayWindow: 10 * time.Second}
+		signer.now = func() time.Time { return now }
+
+		first, err := signer.Token(context.Background(), "http://a/registration/client")
+		require.NoError(t, err)
+
+		now = now.Add(10 * time.Second)
+		renewed, err := signer.Token(context.Background(), "http://a/registration/client")
+		require.NoError(t, err)
+		assert.NotEqual(t, first, renewed)
+	})
+
+	t.Run("a reloaded key replaces cached assertions", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jwk.json")
+		writeKey := func(key jose.JSONWebKey) {
+			data, err := json.Marshal(key)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(path, data, 0o600))
+		}
+		writeKey(key)
+		keys, err := jwk.LoadSigningKeyStore(path, "", nil)
+		require.NoError(t, err)
+
+		signer := &ClientAssertionSigner{ClientID: "jwker", Keys: keys}
+		signer.now = func() time.Time { return now }
+
+		first, err := signer.Token(context.Background(), "http://a/registration/client")
+		require.NoError(t, err)
+
+		next, err := jwk.Generate()
+		require.NoError(t, err)
+		writeKey(next)
+		_, err = keys.Reload()
+		require.NoError(t, err)
+
+		renewed, err := signer.Token(context.Background(), "http://a/registration/client")
+		require.NoError(t, err)
+		assert.NotEqual(t, first, renewed)
+
+		sign, err := jose.ParseSignedCompact(renewed, []jose.SignatureAlgorithm{jose.RS256})
+		require.NoError(t, err)
+		assert.Equal(t, next.KeyID, sign.Signatures[0].Header.KeyID)
+		_, err = sign.Verify(next.Public())
+		assert.NoError(t, err)
+	})
 }
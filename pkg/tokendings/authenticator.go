@@ -0,0 +1,220 @@
+package tokendings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+
+	"github.com/nais/jwker/pkg/jwk"
+)
+
+// Authenticator provides the bearer token that jwker presents to a Tokendings instance.
+type Authenticator interface {
+	// Token returns a bearer token for a request to endpoint.
+	Token(ctx context.Context, endpoint string) (string, error)
+}
+
+// ClientAssertionSigner authenticates with a client assertion signed by jwker's own private key.
+// Signed assertions are cached per audience, and reused until a quarter of their lifetime remains or the active key changes.
+type ClientAssertionSigner struct {
+	ClientID string
+	Keys     *jwk.SigningKeyStore
+	// Lifetime of each assertion. Values below 1 use DefaultClientAssertionLifetime.
+	Lifetime time.Duration
+	// ReplayWindow, if set, limits how long an assertion, and thus its jti, is reused,
+	// for servers that reject a jti after some time. Values below 1 reuse assertions until near expiry.
+	ReplayWindow time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedAssertion
+	now   func() time.Time
+}
+
+type cachedAssertion struct {
+	raw        string
+	key        *jose.JSONWebKey
+	reuseUntil time.Time
+}
+
+func (a *ClientAssertionSigner) Token(_ context.Context, endpoint string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if a.now != nil {
+		now = a.now()
+	}
+
+	key := a.Keys.Active()
+	if key == nil {
+		return "", fmt.Errorf("no signing key for client assertions")
+	}
+
+	if cached, ok := a.cache[endpoint]; ok && cached.key == key && now.Before(cached.reuseUntil) {
+		return cached.raw, nil
+	}
+
+	lifetime := a.Lifetime
+	if lifetime < 1 {
+		lifetime = DefaultClientAssertionLifetime
+	}
+
+	raw, err := signClaims(key, Claims(a.ClientID, endpoint, now, lifetime))
+	if err != nil {
+		return "", err
+	}
+
+	reuseUntil := now.Add(lifetime - lifetime/4)
+	if a.ReplayWindow > 0 && a.ReplayWindow < lifetime-lifetime/4 {
+		reuseUntil = now.Add(a.ReplayWindow)
+	}
+
+	if a.cache == nil {
+		a.cache = make(map[string]cachedAssertion)
+	}
+	for audience, cached := range a.cache {
+		if cached.key != key || !now.Before(cached.reuseUntil) {
+			delete(a.cache, audience)
+		}
+	}
+	a.cache[endpoint] = cachedAssertion{raw: raw, key: key, reuseUntil: reuseUntil}
+	return raw, nil
+}
+
+// BearerTokenFile authenticates with a static bearer token read from a file, e.g. a mounted secret.
+type BearerTokenFile struct {
+	Path string
+}
+
+func (a *BearerTokenFile) Token(_ context.Context, _ string) (string, error) {
+	return readTokenFile(a.Path)
+}
+
+func readTokenFile(path string) (string, error) {
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read token from %s: %w", path, err)
+	}
+
+	accessToken := strings.TrimSpace(string(token))
+	if accessToken == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return accessToken, nil
+}
+
+const (
+	// ExecPluginEndpointEnv is set to the endpoint being called when running an ExecPlugin.
+	ExecPluginEndpointEnv = "TOKENDINGS_ENDPOINT"
+
+	execPluginTimeout = 10 * time.Second
+)
+
+// ExecPlugin authenticates with a token printed to stdout by a local command.
+// The output is either the bare token, or a Kubernetes ExecCredential with the token in status.token.
+type ExecPlugin struct {
+	Command string
+	Args    []string
+	// Timeout bounds a single run of the command. Values below 1 only use the request's context.
+	Timeout time.Duration
+}
+
+func (a *ExecPlugin) Token(ctx context.Context, endpoint string) (string, error) {
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, a.Command, a.Args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", ExecPluginEndpointEnv, endpoint))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running %s: %w: %s", a.Command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	output := bytes.TrimSpace(stdout.Bytes())
+	if bytes.HasPrefix(output, []byte("{")) {
+		var credential struct {
+			Status struct {
+				Token string `json:"token"`
+			} `json:"status"`
+		}
+		if err := json.Unmarshal(output, &credential); err != nil {
+			return "", fmt.Errorf("parsing output of %s: %w", a.Command, err)
+		}
+		output = []byte(credential.Status.Token)
+	}
+
+	if len(output) == 0 {
+		return "", fmt.Errorf("%s did not print a token", a.Command)
+	}
+	return string(output), nil
+}
+
+// ParseAuthenticator parses an authenticator specification on one of the forms
+//
+//	client-assertion
+//	service-account:<path>
+//	bearer-file:<path>
+//	exec:<command> [args...]
+//
+// Client assertions are signed with the active key in clientKeys on behalf of clientID. The command and arguments of an
+// exec plugin are separated by whitespace, without quoting. As authenticators are given in a comma separated list, see
+// ParseAuthenticators, they cannot contain commas either; a command that needs them must be wrapped in a script.
+func ParseAuthenticator(spec, clientID string, clientKeys *jwk.SigningKeyStore) (Authenticator, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	arg = strings.TrimSpace(arg)
+
+	switch kind {
+	case "client-assertion":
+		return &ClientAssertionSigner{ClientID: clientID, Keys: clientKeys}, nil
+	case "service-account":
+		if arg == "" {
+			return nil, fmt.Errorf("authenticator %q requires a token path", spec)
+		}
+		return NewServiceAccountToken(arg, nil), nil
+	case "bearer-file":
+		if arg == "" {
+			return nil, fmt.Errorf("authenticator %q requires a token path", spec)
+		}
+		return &BearerTokenFile{Path: arg}, nil
+	case "exec":
+		fields := strings.Fields(arg)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("authenticator %q requires a command", spec)
+		}
+		return &ExecPlugin{Command: fields[0], Args: fields[1:], Timeout: execPluginTimeout}, nil
+	default:
+		return nil, fmt.Errorf("unknown authenticator %q; must be one of 'client-assertion', 'service-account:<path>', 'bearer-file:<path>' or 'exec:<command>'", spec)
+	}
+}
+
+// ParseAuthenticators parses a comma separated list of baseURL=authenticator pairs; see ParseAuthenticator.
+func ParseAuthenticators(s, clientID string, clientKeys *jwk.SigningKeyStore) (map[string]Authenticator, error) {
+	pairs, err := parsePairs(s, "authenticator", "baseURL=authenticator")
+	if err != nil {
+		return nil, err
+	}
+
+	authenticators := make(map[string]Authenticator, len(pairs))
+	for _, p := range pairs {
+		authenticator, err := ParseAuthenticator(p.value, clientID, clientKeys)
+		if err != nil {
+			return nil, fmt.Errorf("tokendings instance %s: %w", p.key, err)
+		}
+		authenticators[p.key] = authenticator
+	}
+	return authenticators, nil
+}
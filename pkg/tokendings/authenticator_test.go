@@ -0,0 +1,94 @@
+package tokendings
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nais/jwker/pkg/jwk"
+)
+
+func TestAuthenticators(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	tokenPath := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("some-token\n"), 0o600))
+
+	t.Run("bearer token file", func(t *testing.T) {
+		token, err := (&BearerTokenFile{Path: tokenPath}).Token(ctx, "http://endpoint")
+		require.NoError(t, err)
+		assert.Equal(t, "some-token", token)
+
+		_, err = (&BearerTokenFile{Path: filepath.Join(dir, "missing")}).Token(ctx, "http://endpoint")
+		assert.Error(t, err)
+	})
+
+	t.Run("exec plugin", func(t *testing.T) {
+		token, err := (&ExecPlugin{Command: "sh", Args: []string{"-c", "echo token-for-$TOKENDINGS_ENDPOINT"}}).Token(ctx, "http://endpoint")
+		require.NoError(t, err)
+		assert.Equal(t, "token-for-http://endpoint", token)
+
+		token, err = (&ExecPlugin{Command: "sh", Args: []string{"-c", `echo '{"kind":"ExecCredential","status":{"token":"exec-credential-token"}}'`}}).Token(ctx, "http://endpoint")
+		require.NoError(t, err)
+		assert.Equal(t, "exec-credential-token", token)
+
+		_, err = (&ExecPlugin{Command: "sh", Args: []string{"-c", "echo boom >&2; exit 1"}}).Token(ctx, "http://endpoint")
+		assert.ErrorContains(t, err, "boom")
+
+		_, err = (&ExecPlugin{Command: "true"}).Token(ctx, "http://endpoint")
+		assert.ErrorContains(t, err, "did not print a token")
+	})
+
+	t.Run("client assertion", func(t *testing.T) {
+		key, err := jwk.Generate()
+		require.NoError(t, err)
+
+		token, err := (&ClientAssertionSigner{ClientID: "jwker", Keys: signingKeys(&key)}).Token(ctx, "http://endpoint")
+		require.NoError(t, err)
+
+		_, err = jose.ParseSignedCompact(token, []jose.SignatureAlgorithm{jose.RS256})
+		assert.NoError(t, err)
+	})
+}
+
+func TestParseAuthenticators(t *testing.T) {
+	key, err := jwk.Generate()
+	require.NoError(t, err)
+	keys := signingKeys(&key)
+
+	authenticators, err := ParseAuthenticators("http://a=client-assertion, http://b=service-account:/var/run/token,http://c=bearer-file:/etc/token,http://d=exec:/bin/get-token --audience tokendings", "jwker", keys)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Authenticator{
+		"http://a": &ClientAssertionSigner{ClientID: "jwker", Keys: keys},
+		"http://b": NewServiceAccountToken("/var/run/token", nil),
+		"http://c": &BearerTokenFile{Path: "/etc/token"},
+		"http://d": &ExecPlugin{Command: "/bin/get-token", Args: []string{"--audience", "tokendings"}, Timeout: execPluginTimeout},
+	}, authenticators)
+
+	for _, invalid := range []string{"http://a", "http://a=unknown", "http://a=service-account", "http://a=exec:", "http://a=client-assertion,http://a=bearer-file:/etc/token", "http://a=exec:/bin/get-token --scopes a,b"} {
+		_, err := ParseAuthenticators(invalid, "jwker", keys)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestInstance_Authenticator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer from-plugin", r.Header.Get("Authorization"))
+		echoRegistration(w, r)
+	}))
+	defer server.Close()
+
+	instance := NewInstance(server.URL, "jwker", nil, metadata(server.URL), "", server.Client())
+	instance.Authenticator = &ExecPlugin{Command: "echo", Args: []string{"from-plugin"}}
+
+	_, err := instance.RegisterClient(context.Background(), &ClientRegistration{ClientName: "cluster1:team1:app1"}, nil)
+	assert.NoError(t, err)
+}
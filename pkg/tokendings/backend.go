@@ -0,0 +1,342 @@
+package tokendings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Backend is the client registration protocol of an authorization server.
+// Its methods make a single attempt; Instance adds retries and the circuit breaker.
+type Backend interface {
+	// Register creates or replaces the client. previous is the state from the client's latest registration, if any.
+	Register(ctx context.Context, instance *Instance, registration *ClientRegistration, previous *RegistrationState) (*ClientRegistrationResponse, error)
+	// Read returns the client as registered. Reading a client that does not exist returns an error wrapping ErrNotFound.
+	Read(ctx context.Context, instance *Instance, clientID ClientID, previous *RegistrationState) (*ClientRegistrationResponse, error)
+	// Update replaces the registration of an existing client. Updating a client that does not exist returns an error wrapping ErrNotFound.
+	Update(ctx context.Context, instance *Instance, clientID ClientID, registration *ClientRegistration, previous *RegistrationState) (*ClientRegistrationResponse, error)
+	// Delete removes the client. Deleting a client that does not exist returns an error wrapping ErrNotFound.
+	Delete(ctx context.Context, instance *Instance, clientID ClientID, previous *RegistrationState) error
+	// Probe checks that the server is available and accepts jwker's credentials, without changing anything; see Instance.Probe.
+	Probe(ctx context.Context, instance *Instance) error
+}
+
+// RegistrationState is what a server assigned to a client when it was registered, and is needed to manage the client later.
+// Tokendings assigns nothing, as it identifies clients by their ClientID.
+type RegistrationState struct {
+	ClientID                string
+	RegistrationAccessToken string
+	RegistrationClientURI   string
+}
+
+// BackendKind names a Backend in configuration.
+type BackendKind string
+
+const (
+	// BackendTokendings is Tokendings' own registration protocol.
+	BackendTokendings BackendKind = "tokendings"
+	// BackendRFC7591 is standard OAuth 2.0 dynamic client registration.
+	BackendRFC7591 BackendKind = "rfc7591"
+)
+
+// ParseBackends parses a comma separated list of baseURL=backend pairs, where backend is either 'tokendings' or 'rfc7591'.
+func ParseBackends(s string) (map[string]Backend, error) {
+	pairs, err := parsePairs(s, "backend", "baseURL=backend")
+	if err != nil {
+		return nil, err
+	}
+
+	backends := make(map[string]Backend, len(pairs))
+	for _, p := range pairs {
+		switch BackendKind(strings.TrimSpace(p.value)) {
+		case BackendTokendings:
+			backends[p.key] = TokendingsBackend{}
+		case BackendRFC7591:
+			backends[p.key] = RFC7591Backend{}
+		default:
+			return nil, fmt.Errorf("tokendings instance %s: unknown backend %q; must be one of %q or %q", p.key, p.value, BackendTokendings, BackendRFC7591)
+		}
+	}
+	return backends, nil
+}
+
+// TokendingsBackend registers clients with Tokendings' own protocol: the client registration, including a software statement
+// signed by jwker, is posted to /registration/client. Existing clients are read, updated and deleted at
+// /registration/client/{id}, in the style of RFC 7592, where id is their ClientID.
+type TokendingsBackend struct{}
+
+func (TokendingsBackend) Register(ctx context.Context, t *Instance, registration *ClientRegistration, _ *RegistrationState) (*ClientRegistrationResponse, error) {
+	const operation = "unable to register application with tokendings"
+	endpoint := fmt.Sprintf("%s/registration/client", t.BaseURL)
+
+	data, err := json.Marshal(registration)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := t.getAccessToken(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get token for invoking tokendings: %w", err)
+	}
+
+	return sendRegistration(ctx, t.HTTPClient, operation, "POST", endpoint, accessToken, data, http.StatusCreated)
+}
+
+func (TokendingsBackend) Read(ctx context.Context, t *Instance, appClientId ClientID, _ *RegistrationState) (*ClientRegistrationResponse, error) {
+	const operation = "read client from tokendings"
+	endpoint := fmt.Sprintf("%s/registration/client", t.BaseURL)
+
+	accessToken, err := t.getAccessToken(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get token for invoking tokendings: %w", err)
+	}
+
+	return sendRegistration(ctx, t.HTTPClient, operation, "GET", clientEndpoint(endpoint, appClientId), accessToken, nil, http.StatusOK)
+}
+
+func (TokendingsBackend) Update(ctx context.Context, t *Instance, appClientId ClientID, registration *ClientRegistration, _ *RegistrationState) (*ClientRegistrationResponse, error) {
+	const operation = "update client in tokendings"
+	endpoint := fmt.Sprintf("%s/registration/client", t.BaseURL)
+
+	data, err := json.Marshal(registration)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := t.getAccessToken(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get token for invoking tokendings: %w", err)
+	}
+
+	return sendRegistration(ctx, t.HTTPClient, operation, "PUT", clientEndpoint(endpoint, appClientId), accessToken, data, http.StatusOK)
+}
+
+func (TokendingsBackend) Delete(ctx context.Context, t *Instance, appClientId ClientID, _ *RegistrationState) error {
+	const operation = "delete client from tokendings"
+	endpoint := fmt.Sprintf("%s/registration/client", t.BaseURL)
+
+	accessToken, err := t.getAccessToken(ctx, endpoint)
+	if err != nil {
+		return fmt.Errorf("unable to get token for invoking tokendings: %w", err)
+	}
+
+	return deleteRegistration(ctx, t.HTTPClient, operation, clientEndpoint(endpoint, appClientId), accessToken)
+}
+
+func (TokendingsBackend) Probe(ctx context.Context, t *Instance) error {
+	return probeEndpoint(ctx, t, "probe tokendings", fmt.Sprintf("%s/registration/client", t.BaseURL))
+}
+
+// clientEndpoint returns the endpoint for an existing client below endpoint. The audience of tokens for it is still endpoint.
+func clientEndpoint(endpoint string, appClientId ClientID) string {
+	return fmt.Sprintf("%s/%s", endpoint, url.QueryEscape(appClientId.String()))
+}
+
+// RFC7591Backend registers clients with OAuth 2.0 dynamic client registration, as described in RFC 7591.
+// New clients are registered at the registration_endpoint from the server's metadata, authenticated with the instance's
+// Authenticator as the initial access token. The server assigns a client ID, a registration access token and a client
+// configuration URI, which are used to read, update and delete the client as described in RFC 7592.
+// A client whose configuration URI no longer accepts its registration access token is treated as not found, and is registered anew.
+type RFC7591Backend struct{}
+
+func (b RFC7591Backend) Register(ctx context.Context, t *Instance, registration *ClientRegistration, previous *RegistrationState) (*ClientRegistrationResponse, error) {
+	if previous.manageable() {
+		response, err := b.Update(ctx, t, ClientID{}, registration, previous)
+		if !errors.Is(err, ErrNotFound) {
+			return response, err
+		}
+		// the client or its registration access token is gone, e.g. because the server was reset
+	}
+
+	const operation = "unable to register client with dynamic client registration"
+
+	endpoint := t.Metadata.RegistrationEndpoint()
+	if endpoint == "" {
+		return nil, fmt.Errorf("%s: %w: %s has no registration_endpoint in its metadata", operation, ErrPermanent, t.BaseURL)
+	}
+
+	data, err := json.Marshal(newDynamicClientRegistration(registration, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := t.getAccessToken(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get initial access token: %w", err)
+	}
+
+	response, err := sendRegistration(ctx, t.HTTPClient, operation, "POST", endpoint, accessToken, data, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	if !response.State().manageable() {
+		return nil, fmt.Errorf("%s: %w: response lacks client_id, registration_client_uri or registration_access_token", operation, ErrRegistrationMismatch)
+	}
+	return response, nil
+}
+
+func (RFC7591Backend) Read(ctx context.Context, t *Instance, _ ClientID, previous *RegistrationState) (*ClientRegistrationResponse, error) {
+	const operation = "unable to read client with dynamic client registration"
+
+	if !previous.manageable() {
+		return nil, fmt.Errorf("%s: %w: no registration client URI and access token are known for the client", operation, ErrNotFound)
+	}
+
+	response, err := sendRegistration(ctx, t.HTTPClient, operation, "GET", previous.RegistrationClientURI, previous.RegistrationAccessToken, nil, http.StatusOK)
+	if err != nil {
+		return nil, clientConfigurationError(err)
+	}
+	return response.withState(operation, previous)
+}
+
+func (RFC7591Backend) Update(ctx context.Context, t *Instance, _ ClientID, registration *ClientRegistration, previous *RegistrationState) (*ClientRegistrationResponse, error) {
+	const operation = "unable to update client with dynamic client registration"
+
+	if !previous.manageable() {
+		return nil, fmt.Errorf("%s: %w: no registration client URI and access token are known for the client", operation, ErrNotFound)
+	}
+
+	data, err := json.Marshal(newDynamicClientRegistration(registration, previous.ClientID))
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := sendRegistration(ctx, t.HTTPClient, operation, "PUT", previous.RegistrationClientURI, previous.RegistrationAccessToken, data, http.StatusOK)
+	if err != nil {
+		return nil, clientConfigurationError(err)
+	}
+	return response.withState(operation, previous)
+}
+
+func (RFC7591Backend) Delete(ctx context.Context, t *Instance, _ ClientID, previous *RegistrationState) error {
+	const operation = "delete client with dynamic client registration"
+
+	if !previous.manageable() {
+		return fmt.Errorf("%s: %w: no registration client URI and access token are known for the client", operation, ErrNotFound)
+	}
+	return clientConfigurationError(deleteRegistration(ctx, t.HTTPClient, operation, previous.RegistrationClientURI, previous.RegistrationAccessToken))
+}
+
+func (RFC7591Backend) Probe(ctx context.Context, t *Instance) error {
+	const operation = "probe dynamic client registration"
+
+	endpoint := t.Metadata.RegistrationEndpoint()
+	if endpoint == "" {
+		return fmt.Errorf("%s: %w: %s has no registration_endpoint in its metadata", operation, ErrPermanent, t.BaseURL)
+	}
+	return probeEndpoint(ctx, t, operation, endpoint)
+}
+
+// dynamicClientRegistration is the client metadata sent in RFC 7591 registration and RFC 7592 update requests.
+type dynamicClientRegistration struct {
+	ClientRegistration
+	GrantTypes              []string `json:"grant_types"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	// ClientID is required in update requests, and must be omitted when registering.
+	ClientID string `json:"client_id,omitempty"`
+}
+
+func newDynamicClientRegistration(registration *ClientRegistration, clientID string) dynamicClientRegistration {
+	return dynamicClientRegistration{
+		ClientRegistration:      *registration,
+		GrantTypes:              []string{TokenExchangeGrantType},
+		TokenEndpointAuthMethod: PrivateKeyJwtAuthMethod,
+		ClientID:                clientID,
+	}
+}
+
+// withState fills in the state that RFC 7592 allows the server to omit from responses when unchanged,
+// such as a registration access token that was not rotated.
+func (r *ClientRegistrationResponse) withState(operation string, previous *RegistrationState) (*ClientRegistrationResponse, error) {
+	if r.ClientID == "" {
+		r.ClientID = previous.ClientID
+	}
+	if r.RegistrationClientURI == "" {
+		r.RegistrationClientURI = previous.RegistrationClientURI
+	}
+	if r.RegistrationAccessToken == "" {
+		r.RegistrationAccessToken = previous.RegistrationAccessToken
+	}
+	if r.ClientID != previous.ClientID {
+		return nil, fmt.Errorf("%s: %w: client ID is %q, expected %q", operation, ErrRegistrationMismatch, r.ClientID, previous.ClientID)
+	}
+	return r, nil
+}
+
+// clientConfigurationError treats 401 Unauthorized from a client configuration endpoint as not found,
+// as RFC 7592 responds so for clients that do not exist, and revokes their tokens.
+func clientConfigurationError(err error) error {
+	if errors.Is(err, ErrUnauthorized) {
+		return fmt.Errorf("%w: %s", ErrNotFound, err)
+	}
+	return err
+}
+
+// manageable reports whether the state has what RFC 7592 requires to manage the client.
+func (s *RegistrationState) manageable() bool {
+	return s != nil && s.ClientID != "" && s.RegistrationClientURI != "" && s.RegistrationAccessToken != ""
+}
+
+// sendRegistration sends data, if any, and decodes the client registration in the response.
+func sendRegistration(ctx context.Context, httpClient *http.Client, operation, method, endpoint, accessToken string, data []byte, expectedStatus int) (*ClientRegistrationResponse, error) {
+	var reader io.Reader
+	if data != nil {
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		request.Header.Add("Content-Type", "application/json")
+	}
+	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return nil, transportError(ctx, operation, err)
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != expectedStatus {
+		return nil, newResponseError(operation, resp, body)
+	}
+	if err != nil {
+		return nil, transportError(ctx, operation, err)
+	}
+
+	response := &ClientRegistrationResponse{}
+	if err := json.Unmarshal(body, response); err != nil {
+		return nil, fmt.Errorf("%s: %w: decoding response: %w", operation, ErrRegistrationMismatch, err)
+	}
+	return response, nil
+}
+
+func deleteRegistration(ctx context.Context, httpClient *http.Client, operation, endpoint, accessToken string) error {
+	request, err := http.NewRequestWithContext(ctx, "DELETE", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return transportError(ctx, operation, err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	msg, _ := io.ReadAll(resp.Body)
+	return newResponseError(operation, resp, msg)
+}
@@ -0,0 +1,272 @@
+package tokendings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/nais/liberator/pkg/oauth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nais/jwker/pkg/jwk"
+)
+
+// dynamicRegistrationServer is an authorization server that supports RFC 7591 registration and RFC 7592 management.
+type dynamicRegistrationServer struct {
+	*httptest.Server
+	initialAccessToken string
+
+	mu       sync.Mutex
+	clients  map[string]dynamicClientRegistration
+	tokens   map[string]string
+	assigned int
+	updates  int
+}
+
+func newDynamicRegistrationServer(t *testing.T, initialAccessToken string) *dynamicRegistrationServer {
+	s := &dynamicRegistrationServer{
+		initialAccessToken: initialAccessToken,
+		clients:            make(map[string]dynamicClientRegistration),
+		tokens:             make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+oauth.WellKnownOAuthSuffix, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                s.URL,
+			"jwks_uri":              s.URL + "/jwks",
+			"token_endpoint":        s.URL + "/token",
+			"registration_endpoint": s.URL + "/register",
+		})
+	})
+	mux.HandleFunc("POST /register", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+s.initialAccessToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var client dynamicClientRegistration
+		if err := json.NewDecoder(r.Body).Decode(&client); err != nil || client.ClientID != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		s.assigned++
+		client.ClientID = fmt.Sprintf("client-%d", s.assigned)
+		s.clients[client.ClientID] = client
+		s.tokens[client.ClientID] = fmt.Sprintf("token-%d", s.assigned)
+		s.mu.Unlock()
+
+		s.respond(w, http.StatusCreated, client)
+	})
+	mux.HandleFunc("GET /register/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		s.mu.Lock()
+		client := s.clients[r.PathValue("id")]
+		s.mu.Unlock()
+
+		s.respond(w, http.StatusOK, client)
+	})
+	mux.HandleFunc("PUT /register/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var client dynamicClientRegistration
+		if err := json.NewDecoder(r.Body).Decode(&client); err != nil || client.ClientID != r.PathValue("id") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		s.clients[client.ClientID] = client
+		s.updates++
+		s.mu.Unlock()
+
+		s.respond(w, http.StatusOK, client)
+	})
+	mux.HandleFunc("DELETE /register/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		s.mu.Lock()
+		delete(s.clients, r.PathValue("id"))
+		delete(s.tokens, r.PathValue("id"))
+		s.mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *dynamicRegistrationServer) authorized(r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[r.PathValue("id")]
+	return ok && r.Header.Get("Authorization") == "Bearer "+token
+}
+
+func (s *dynamicRegistrationServer) respond(w http.ResponseWriter, status int, client dynamicClientRegistration) {
+	s.mu.Lock()
+	token := s.tokens[client.ClientID]
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ClientRegistrationResponse{
+		ClientRegistration:      client.ClientRegistration,
+		GrantTypes:              client.GrantTypes,
+		TokenEndpointAuthMethod: client.TokenEndpointAuthMethod,
+		ClientID:                client.ClientID,
+		RegistrationAccessToken: token,
+		RegistrationClientURI:   s.URL + "/register/" + client.ClientID,
+	})
+}
+
+func TestRFC7591Backend(t *testing.T) {
+	server := newDynamicRegistrationServer(t, "initial-access-token")
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("initial-access-token"), 0o600))
+
+	instance := NewInstance(server.URL, "jwker", nil, nil, "", server.Client())
+	instance.Authenticator = &BearerTokenFile{Path: tokenFile}
+	instance.Backend = RFC7591Backend{}
+	instance.Metadata = NewMetadata(server.URL+oauth.WellKnownOAuthSuffix, nil)
+	instance.Metadata.HTTPClient = server.Client()
+	require.NoError(t, instance.Metadata.Resolve(context.Background()))
+	assert.Equal(t, server.URL+"/register", instance.Metadata.RegistrationEndpoint())
+
+	key, err := jwk.Generate()
+	require.NoError(t, err)
+	registration := &ClientRegistration{
+		ClientName:        "cluster1:team1:app1",
+		Jwks:              jose.JSONWebKeySet{Keys: []jose.JSONWebKey{key.Public()}},
+		SoftwareStatement: "signedstatement",
+	}
+
+	var state *RegistrationState
+
+	t.Run("a new client is registered at the registration endpoint", func(t *testing.T) {
+		response, err := instance.RegisterClient(context.Background(), registration, nil)
+		require.NoError(t, err)
+
+		state = response.State()
+		require.NotNil(t, state)
+		assert.Equal(t, RegistrationState{
+			ClientID:                "client-1",
+			RegistrationAccessToken: "token-1",
+			RegistrationClientURI:   server.URL + "/register/client-1",
+		}, *state)
+		assert.Equal(t, []string{TokenExchangeGrantType}, server.clients["client-1"].GrantTypes)
+		assert.Equal(t, "signedstatement", server.clients["client-1"].SoftwareStatement)
+	})
+
+	t.Run("a known client is updated in place", func(t *testing.T) {
+		rotated, err := jwk.Generate()
+		require.NoError(t, err)
+		updated := *registration
+		updated.Jwks = jose.JSONWebKeySet{Keys: []jose.JSONWebKey{rotated.Public(), key.Public()}}
+
+		response, err := instance.RegisterClient(context.Background(), &updated, state)
+		require.NoError(t, err)
+		assert.Equal(t, state, response.State())
+		assert.Equal(t, 1, server.updates)
+		assert.Len(t, server.clients, 1)
+		assert.Len(t, server.clients["client-1"].Jwks.Keys, 2)
+	})
+
+	t.Run("a known client is read from its configuration endpoint", func(t *testing.T) {
+		response, err := instance.ReadClient(context.Background(), ClientID{}, state)
+		require.NoError(t, err)
+		assert.Equal(t, state, response.State())
+		assert.Len(t, response.Jwks.Keys, 2)
+
+		revoked := *state
+		revoked.RegistrationAccessToken = "revoked"
+		_, err = instance.ReadClient(context.Background(), ClientID{}, &revoked)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("a client whose token is rejected is registered anew", func(t *testing.T) {
+		revoked := *state
+		revoked.RegistrationAccessToken = "revoked"
+
+		response, err := instance.RegisterClient(context.Background(), registration, &revoked)
+		require.NoError(t, err)
+		assert.Equal(t, "client-2", response.ClientID)
+	})
+
+	t.Run("a known client is deleted", func(t *testing.T) {
+		err := instance.DeleteClient(context.Background(), ClientID{}, state)
+		require.NoError(t, err)
+		assert.NotContains(t, server.clients, "client-1")
+
+		err = instance.DeleteClient(context.Background(), ClientID{}, state)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.False(t, IsRetryable(err))
+	})
+
+	t.Run("a client without state cannot be deleted", func(t *testing.T) {
+		err := instance.DeleteClient(context.Background(), ClientID{}, nil)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("servers without a registration endpoint are rejected", func(t *testing.T) {
+		unsupported := instance
+		unsupported.Metadata = NewMetadata("", metadata(server.URL))
+
+		_, err := unsupported.RegisterClient(context.Background(), registration, nil)
+		assert.True(t, IsPermanent(err))
+		assert.ErrorContains(t, err, "registration_endpoint")
+
+		assert.ErrorContains(t, unsupported.Probe(context.Background()), "registration_endpoint", "should be unhealthy")
+	})
+
+	t.Run("the registration endpoint is probed", func(t *testing.T) {
+		assert.NoError(t, instance.Probe(context.Background()))
+	})
+}
+
+func TestParseBackends(t *testing.T) {
+	backends, err := ParseBackends("https://a=tokendings, https://b=rfc7591")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Backend{
+		"https://a": TokendingsBackend{},
+		"https://b": RFC7591Backend{},
+	}, backends)
+
+	backends, err = ParseBackends("")
+	require.NoError(t, err)
+	assert.Empty(t, backends)
+
+	_, err = ParseBackends("https://a=saml")
+	assert.ErrorContains(t, err, "unknown backend")
+
+	_, err = ParseBackends("rfc7591")
+	assert.Error(t, err)
+
+	_, err = ParseBackends("https://a=tokendings, https://a=rfc7591")
+	assert.ErrorContains(t, err, "https://a is given more than once")
+}
@@ -0,0 +1,193 @@
+package tokendings
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting Tokendings while the instance's circuit breaker is open.
+var ErrCircuitOpen = fmt.Errorf("circuit breaker is open")
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitHalfOpen
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+type CircuitBreakerOptions struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker. Values below 1 disable the breaker.
+	FailureThreshold int
+	// OpenDuration is how long the breaker stays open before letting a single probe request through.
+	OpenDuration time.Duration
+}
+
+func DefaultCircuitBreakerOptions() CircuitBreakerOptions {
+	return CircuitBreakerOptions{
+		FailureThreshold: 5,
+		OpenDuration:     30 * time.Second,
+	}
+}
+
+// CircuitBreaker stops requests to an instance after repeated failures.
+// Once OpenDuration has passed, the breaker becomes half-open and lets one probe request through;
+// the breaker closes if the probe succeeds and opens again if it fails.
+type CircuitBreaker struct {
+	opts          CircuitBreakerOptions
+	onStateChange func(CircuitState)
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	probing  bool
+	timer    *time.Timer
+}
+
+// NewCircuitBreaker returns a closed breaker. onStateChange, if set, is called on every transition and must not block.
+func NewCircuitBreaker(opts CircuitBreakerOptions, onStateChange func(CircuitState)) *CircuitBreaker {
+	b := &CircuitBreaker{
+		opts:          opts,
+		onStateChange: onStateChange,
+	}
+	if onStateChange != nil {
+		onStateChange(CircuitClosed)
+	}
+	return b
+}
+
+func (b *CircuitBreaker) State() CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// call runs fn unless the breaker is open, and records its outcome.
+func (b *CircuitBreaker) call(fn func() error) error {
+	if b == nil || b.opts.FailureThreshold < 1 {
+		return fn()
+	}
+
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	err := fn()
+	b.record(err)
+	return err
+}
+
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		return ErrCircuitOpen
+	case CircuitHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	switch {
+	case countsAsFailure(err):
+		b.failures++
+		if b.state == CircuitHalfOpen || b.failures >= b.opts.FailureThreshold {
+			b.open()
+		}
+	case err == nil || isResponse(err):
+		b.failures = 0
+		b.setState(CircuitClosed)
+	default:
+		// errors that never reached Tokendings, e.g. a cancelled context, say nothing about its health
+	}
+}
+
+func (b *CircuitBreaker) open() {
+	b.setState(CircuitOpen)
+
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.timer = time.AfterFunc(b.opts.OpenDuration, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if b.state == CircuitOpen {
+			b.setState(CircuitHalfOpen)
+		}
+	})
+}
+
+func (b *CircuitBreaker) setState(state CircuitState) {
+	if b.state == state {
+		return
+	}
+
+	b.state = state
+	if b.onStateChange != nil {
+		b.onStateChange(state)
+	}
+}
+
+// countsAsFailure reports whether err indicates that the instance itself is unhealthy.
+// Rejections of a particular request, such as 400, 401 or 404, mean that the instance is up and responding.
+func countsAsFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, ErrRetryable)
+}
+
+func isResponse(err error) bool {
+	var tdErr *Error
+	return errors.As(err, &tdErr)
+}
+
+// CircuitBreakerCheck returns a health check that fails if the circuit breaker of every instance is open.
+func CircuitBreakerCheck(instances []Instance) func(*http.Request) error {
+	return func(_ *http.Request) error {
+		open := make([]string, 0)
+		for _, instance := range instances {
+			if instance.CircuitBreaker.State() == CircuitOpen {
+				open = append(open, instance.BaseURL)
+			}
+		}
+
+		if len(instances) > 0 && len(open) == len(instances) {
+			return fmt.Errorf("circuit breaker is open for all Tokendings instances: %s", strings.Join(open, ", "))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,76 @@
+package tokendings
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	unavailable := &Error{Status: "503 Service Unavailable", kind: ErrRetryable}
+	badRequest := &Error{Status: "400 Bad Request", kind: ErrPermanent}
+	unauthorized := &Error{Status: "401 Unauthorized", kind: ErrUnauthorized}
+
+	transitions := make(chan CircuitState, 10)
+	b := NewCircuitBreaker(CircuitBreakerOptions{FailureThreshold: 2, OpenDuration: 50 * time.Millisecond}, func(state CircuitState) {
+		transitions <- state
+	})
+	assert.Equal(t, CircuitClosed, <-transitions)
+
+	calls := 0
+	failing := func() error {
+		calls++
+		return unavailable
+	}
+
+	assert.ErrorIs(t, b.call(failing), ErrRetryable)
+	assert.Equal(t, CircuitClosed, b.State())
+	assert.ErrorIs(t, b.call(failing), ErrRetryable)
+	assert.Equal(t, CircuitOpen, <-transitions)
+
+	// fails fast while open
+	assert.ErrorIs(t, b.call(failing), ErrCircuitOpen)
+	assert.Equal(t, 2, calls)
+
+	// a failed probe opens the breaker again
+	assert.Equal(t, CircuitHalfOpen, <-transitions)
+	assert.ErrorIs(t, b.call(failing), ErrRetryable)
+	assert.Equal(t, CircuitOpen, <-transitions)
+	assert.Equal(t, 3, calls)
+
+	// errors that never reached the instance leave the breaker half-open
+	assert.Equal(t, CircuitHalfOpen, <-transitions)
+	assert.ErrorIs(t, b.call(func() error { return fmt.Errorf("request: %w", context.Canceled) }), context.Canceled)
+	assert.Equal(t, CircuitHalfOpen, b.State())
+
+	// a rejected request means the instance is responding
+	assert.ErrorIs(t, b.call(func() error { return badRequest }), ErrPermanent)
+	assert.Equal(t, CircuitClosed, <-transitions)
+
+	// rejected credentials are not a failure of the instance
+	for range 3 {
+		assert.ErrorIs(t, b.call(func() error { return unauthorized }), ErrUnauthorized)
+	}
+	assert.Equal(t, CircuitClosed, b.State())
+}
+
+func TestCircuitBreakerCheck(t *testing.T) {
+	closed := NewCircuitBreaker(DefaultCircuitBreakerOptions(), nil)
+	open := NewCircuitBreaker(DefaultCircuitBreakerOptions(), nil)
+	open.open()
+
+	check := CircuitBreakerCheck([]Instance{
+		{BaseURL: "http://a", CircuitBreaker: open},
+		{BaseURL: "http://b", CircuitBreaker: closed},
+	})
+	assert.NoError(t, check(nil))
+
+	check = CircuitBreakerCheck([]Instance{
+		{BaseURL: "http://a", CircuitBreaker: open},
+		{BaseURL: "http://b", CircuitBreaker: open},
+	})
+	assert.ErrorContains(t, check(nil), "http://a, http://b")
+}
@@ -0,0 +1,181 @@
+package tokendings
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync/atomic"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// Drift lists the differences between a client as registered with an instance and the registration jwker would make.
+type Drift []string
+
+// ReadSupport records whether an instance is known to support reading clients. Instances that predate reading clients
+// may respond 404 Not Found to the read route, which cannot be told apart from a client that does not exist, so support
+// is only known once a read has succeeded.
+type ReadSupport struct {
+	supported atomic.Bool
+}
+
+// Known reports whether a read has succeeded. It is false for a nil ReadSupport.
+func (r *ReadSupport) Known() bool {
+	return r != nil && r.supported.Load()
+}
+
+func (r *ReadSupport) observe() {
+	if r != nil {
+		r.supported.Store(true)
+	}
+}
+
+// softwareStatementAlgorithms are the algorithms jwker may sign software statements with; see jwk.SigningAlgorithm.
+var softwareStatementAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// DetectDrift compares the client as registered with registration: the client name, keys, grant types and authentication method,
+// and the access policy in the software statement. The access policy is not compared if the instance does not return the
+// software statement it holds.
+func DetectDrift(registered *ClientRegistrationResponse, registration *ClientRegistration) (Drift, error) {
+	drift := make(Drift, 0)
+
+	if registered.ClientName != registration.ClientName {
+		drift = append(drift, fmt.Sprintf("client name is %q, expected %q", registered.ClientName, registration.ClientName))
+	}
+	drift = append(drift, keySetDrift(registered.Jwks, registration.Jwks)...)
+	drift = append(drift, registered.grantMismatches()...)
+
+	if registered.SoftwareStatement == "" {
+		return drift, nil
+	}
+
+	expected, err := parseSoftwareStatement(registration.SoftwareStatement)
+	if err != nil {
+		return nil, fmt.Errorf("parsing expected software statement: %w", err)
+	}
+	actual, err := parseSoftwareStatement(registered.SoftwareStatement)
+	if err != nil {
+		return append(drift, fmt.Sprintf("software statement cannot be parsed: %s", err)), nil
+	}
+
+	if actual.AppId != expected.AppId {
+		drift = append(drift, fmt.Sprintf("software statement is for %q, expected %q", actual.AppId, expected.AppId))
+	}
+	drift = append(drift, setDrift("inbound access policy", actual.AccessPolicyInbound, expected.AccessPolicyInbound)...)
+	drift = append(drift, setDrift("outbound access policy", actual.AccessPolicyOutbound, expected.AccessPolicyOutbound)...)
+	return drift, nil
+}
+
+// SyncClient reads the client from the instance, and updates it if it has drifted from registration. previous is as for RegisterClient.
+// A client that does not exist is registered, as is the client with instances that cannot read or update clients.
+// It returns the client as registered afterwards, and the drift that was repaired, if any. A client that is not found
+// is only reported as drift once the instance is known to support reading clients; see ReadSupport.
+func (t *Instance) SyncClient(ctx context.Context, appClientId ClientID, registration *ClientRegistration, previous *RegistrationState) (*ClientRegistrationResponse, Drift, error) {
+	registered, err := t.ReadClient(ctx, appClientId, previous)
+	switch {
+	case errors.Is(err, ErrNotFound) && t.ReadSupport.Known():
+		response, err := t.RegisterClient(ctx, registration, previous)
+		return response, Drift{"client is not registered"}, err
+	case errors.Is(err, ErrNotFound), IsPermanent(err):
+		// e.g. 404 Not Found or 405 Method Not Allowed from instances that predate reading clients
+		response, err := t.RegisterClient(ctx, registration, previous)
+		return response, nil, err
+	case err != nil:
+		return nil, nil, err
+	}
+
+	drift, err := DetectDrift(registered, registration)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(drift) == 0 {
+		return registered, nil, nil
+	}
+
+	response, err := t.UpdateClient(ctx, appClientId, registration, previous)
+	// the client was deleted since it was read, or the instance cannot update clients, e.g. 405 Method Not Allowed
+	if errors.Is(err, ErrNotFound) || IsPermanent(err) {
+		response, err = t.RegisterClient(ctx, registration, previous)
+	}
+	return response, drift, err
+}
+
+// keySetDrift describes how registered differs from expected, by key ID and thumbprint.
+func keySetDrift(registered, expected jose.JSONWebKeySet) Drift {
+	drift := make(Drift, 0)
+
+	thumbprints := func(set jose.JSONWebKeySet) map[string]string {
+		m := make(map[string]string, len(set.Keys))
+		for _, key := range set.Keys {
+			thumbprint, _ := key.Thumbprint(crypto.SHA256)
+			m[key.KeyID] = string(thumbprint)
+		}
+		return m
+	}
+	actual, wanted := thumbprints(registered), thumbprints(expected)
+
+	missing, unexpected, changed := make([]string, 0), make([]string, 0), make([]string, 0)
+	for _, id := range keyIDs(expected) {
+		thumbprint, ok := actual[id]
+		switch {
+		case !ok:
+			missing = append(missing, id)
+		case thumbprint != wanted[id] || thumbprint == "":
+			changed = append(changed, id)
+		}
+	}
+	for _, id := range keyIDs(registered) {
+		if _, ok := wanted[id]; !ok {
+			unexpected = append(unexpected, id)
+		}
+	}
+
+	if len(missing) > 0 {
+		drift = append(drift, fmt.Sprintf("JWKS is missing key IDs %v", missing))
+	}
+	if len(unexpected) > 0 {
+		drift = append(drift, fmt.Sprintf("JWKS has unexpected key IDs %v", unexpected))
+	}
+	if len(changed) > 0 {
+		drift = append(drift, fmt.Sprintf("JWKS has different keys for key IDs %v", changed))
+	}
+	return drift
+}
+
+// setDrift describes how the values in actual differ from those in expected, regardless of order.
+func setDrift(name string, actual, expected []string) Drift {
+	drift := make(Drift, 0)
+
+	missing := slices.DeleteFunc(slices.Clone(expected), func(v string) bool { return slices.Contains(actual, v) })
+	unexpected := slices.DeleteFunc(slices.Clone(actual), func(v string) bool { return slices.Contains(expected, v) })
+	if len(missing) > 0 {
+		drift = append(drift, fmt.Sprintf("%s is missing %v", name, missing))
+	}
+	if len(unexpected) > 0 {
+		drift = append(drift, fmt.Sprintf("%s has unexpected %v", name, unexpected))
+	}
+	return drift
+}
+
+// parseSoftwareStatement returns the claims of a software statement. The signature is not verified,
+// as the statement is either made by jwker itself or returned by an instance that has already verified it.
+func parseSoftwareStatement(raw string) (*SoftwareStatement, error) {
+	signed, err := jose.ParseSigned(raw, softwareStatementAlgorithms)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &SoftwareStatement{}
+	if err := json.Unmarshal(signed.UnsafePayloadWithoutVerification(), statement); err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
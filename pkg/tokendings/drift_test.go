@@ -0,0 +1,97 @@
+package tokendings
+
+import (
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	jwkerv1 "github.com/nais/liberator/pkg/apis/nais.io/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nais/jwker/pkg/jwk"
+)
+
+func TestDetectDrift(t *testing.T) {
+	signer, err := jwk.Generate()
+	require.NoError(t, err)
+	key, err := jwk.Generate()
+	require.NoError(t, err)
+	other, err := jwk.Generate()
+	require.NoError(t, err)
+
+	app := ClientID{Name: "app1", Namespace: "team1", Cluster: "cluster1"}
+	registration := func(t *testing.T, keys []jose.JSONWebKey, inbound ...string) *ClientRegistration {
+		rules := make([]jwkerv1.AccessPolicyInboundRule, 0)
+		for _, application := range inbound {
+			rules = append(rules, jwkerv1.AccessPolicyInboundRule{AccessPolicyRule: jwkerv1.AccessPolicyRule{Application: application}})
+		}
+
+		jwker := jwkerv1.Jwker{Spec: jwkerv1.JwkerSpec{AccessPolicy: &jwkerv1.AccessPolicy{
+			Inbound: &jwkerv1.AccessPolicyInbound{Rules: rules},
+		}}}
+		r, err := MakeClientRegistration(&signer, &jose.JSONWebKeySet{Keys: keys}, app, jwker)
+		require.NoError(t, err)
+		return r
+	}
+
+	expected := registration(t, []jose.JSONWebKey{key.Public()}, "app2")
+	registered := func(r *ClientRegistration) *ClientRegistrationResponse {
+		return &ClientRegistrationResponse{
+			ClientRegistration:      *r,
+			GrantTypes:              []string{TokenExchangeGrantType},
+			TokenEndpointAuthMethod: PrivateKeyJwtAuthMethod,
+		}
+	}
+
+	t.Run("identical registrations have no drift", func(t *testing.T) {
+		// signed again, so that only the claims are equal
+		drift, err := DetectDrift(registered(registration(t, []jose.JSONWebKey{key.Public()}, "app2")), expected)
+		require.NoError(t, err)
+		assert.Empty(t, drift)
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		drift, err := DetectDrift(registered(registration(t, []jose.JSONWebKey{other.Public()}, "app2")), expected)
+		require.NoError(t, err)
+		assert.Equal(t, Drift{
+			"JWKS is missing key IDs [" + key.KeyID + "]",
+			"JWKS has unexpected key IDs [" + other.KeyID + "]",
+		}, drift)
+
+		replaced := other.Public()
+		replaced.KeyID = key.KeyID
+		drift, err = DetectDrift(registered(registration(t, []jose.JSONWebKey{replaced}, "app2")), expected)
+		require.NoError(t, err)
+		assert.Equal(t, Drift{"JWKS has different keys for key IDs [" + key.KeyID + "]"}, drift)
+	})
+
+	t.Run("access policy", func(t *testing.T) {
+		drift, err := DetectDrift(registered(registration(t, []jose.JSONWebKey{key.Public()}, "app3")), expected)
+		require.NoError(t, err)
+		assert.Equal(t, Drift{
+			"inbound access policy is missing [cluster1:team1:app2]",
+			"inbound access policy has unexpected [cluster1:team1:app3]",
+		}, drift)
+	})
+
+	t.Run("access policy is not compared without a software statement", func(t *testing.T) {
+		r := registered(registration(t, []jose.JSONWebKey{key.Public()}, "app3"))
+		r.SoftwareStatement = ""
+
+		drift, err := DetectDrift(r, expected)
+		require.NoError(t, err)
+		assert.Empty(t, drift)
+	})
+
+	t.Run("grant types and client name", func(t *testing.T) {
+		r := registered(expected)
+		r.ClientName = "cluster1:team1:other"
+		r.GrantTypes = nil
+
+		drift, err := DetectDrift(r, expected)
+		require.NoError(t, err)
+		assert.Len(t, drift, 2)
+		assert.Contains(t, drift[0], "client name")
+		assert.Contains(t, drift[1], "grant types")
+	})
+}
@@ -0,0 +1,89 @@
+package tokendings
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nais/jwker/pkg/dryrun"
+)
+
+// DryRunBackend reads clients with the wrapped Backend, but only records the clients it would register, update or delete.
+// Writes respond as if the server accepted the registration unchanged.
+type DryRunBackend struct {
+	// Backend is the wrapped backend. Nil means Tokendings' own protocol.
+	Backend  Backend
+	Recorder *dryrun.Recorder
+}
+
+func (b DryRunBackend) Register(ctx context.Context, t *Instance, registration *ClientRegistration, previous *RegistrationState) (*ClientRegistrationResponse, error) {
+	b.Recorder.Record(ctx, clientChange(t, registration.ClientName, "register", registrationDiff(registration)))
+	return acceptedRegistration(registration, previous), nil
+}
+
+func (b DryRunBackend) Read(ctx context.Context, t *Instance, clientID ClientID, previous *RegistrationState) (*ClientRegistrationResponse, error) {
+	return b.backend().Read(ctx, t, clientID, previous)
+}
+
+func (b DryRunBackend) Update(ctx context.Context, t *Instance, _ ClientID, registration *ClientRegistration, previous *RegistrationState) (*ClientRegistrationResponse, error) {
+	b.Recorder.Record(ctx, clientChange(t, registration.ClientName, "update", registrationDiff(registration)))
+	return acceptedRegistration(registration, previous), nil
+}
+
+func (b DryRunBackend) Delete(ctx context.Context, t *Instance, clientID ClientID, _ *RegistrationState) error {
+	b.Recorder.Record(ctx, clientChange(t, clientID.String(), "delete", nil))
+	return nil
+}
+
+func (b DryRunBackend) Probe(ctx context.Context, t *Instance) error {
+	return b.backend().Probe(ctx, t)
+}
+
+func (b DryRunBackend) backend() Backend {
+	if b.Backend == nil {
+		return TokendingsBackend{}
+	}
+	return b.Backend
+}
+
+func clientChange(t *Instance, clientName, action string, diff []dryrun.Difference) dryrun.Change {
+	return dryrun.Change{
+		Kind:   "Client",
+		Name:   fmt.Sprintf("%s at %s", clientName, t.BaseURL),
+		Action: action,
+		Diff:   diff,
+	}
+}
+
+// registrationDiff describes the registration that would be sent. The software statement is described by its claims.
+func registrationDiff(registration *ClientRegistration) []dryrun.Difference {
+	diff := []dryrun.Difference{
+		{Path: ".client_name", New: registration.ClientName},
+		{Path: ".jwks.keys[].kid", New: strings.Join(keyIDs(registration.Jwks), ",")},
+	}
+
+	statement, err := parseSoftwareStatement(registration.SoftwareStatement)
+	if err != nil {
+		return diff
+	}
+	return append(diff,
+		dryrun.Difference{Path: ".software_statement.appId", New: statement.AppId},
+		dryrun.Difference{Path: ".software_statement.accessPolicyInbound", New: strings.Join(statement.AccessPolicyInbound, ",")},
+		dryrun.Difference{Path: ".software_statement.accessPolicyOutbound", New: strings.Join(statement.AccessPolicyOutbound, ",")},
+	)
+}
+
+// acceptedRegistration is the response of a server that accepted the registration as is, and kept any state it assigned before.
+func acceptedRegistration(registration *ClientRegistration, previous *RegistrationState) *ClientRegistrationResponse {
+	response := &ClientRegistrationResponse{
+		ClientRegistration:      *registration,
+		GrantTypes:              []string{TokenExchangeGrantType},
+		TokenEndpointAuthMethod: PrivateKeyJwtAuthMethod,
+	}
+	if previous != nil {
+		response.ClientID = previous.ClientID
+		response.RegistrationAccessToken = previous.RegistrationAccessToken
+		response.RegistrationClientURI = previous.RegistrationClientURI
+	}
+	return response
+}
@@ -0,0 +1,74 @@
+package tokendings_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	jwkerv1 "github.com/nais/liberator/pkg/apis/nais.io/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nais/jwker/pkg/dryrun"
+	"github.com/nais/jwker/pkg/jwk"
+	"github.com/nais/jwker/pkg/tokendings"
+	"github.com/nais/jwker/pkg/tokendings/tokendingstest"
+)
+
+func TestDryRunBackend(t *testing.T) {
+	server := tokendingstest.NewServer()
+	defer server.Close()
+
+	changes := make([]dryrun.Change, 0)
+	recorder := &dryrun.Recorder{OnChange: func(c dryrun.Change) { changes = append(changes, c) }}
+
+	signer, err := jwk.Generate()
+	require.NoError(t, err)
+	keys := jwk.NewSigningKeyStore(&jwk.SigningKeys{Active: signer, Keys: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{signer}}})
+	instance := tokendings.NewInstance(server.URL, "jwker", keys, server.Metadata(), "", server.Client())
+	instance.Backend = tokendings.DryRunBackend{Recorder: recorder}
+
+	key, err := jwk.Generate()
+	require.NoError(t, err)
+	app := tokendings.ClientID{Name: "app1", Namespace: "team1", Cluster: "cluster1"}
+	jwker := jwkerv1.Jwker{Spec: jwkerv1.JwkerSpec{AccessPolicy: &jwkerv1.AccessPolicy{
+		Inbound: &jwkerv1.AccessPolicyInbound{Rules: []jwkerv1.AccessPolicyInboundRule{
+			{AccessPolicyRule: jwkerv1.AccessPolicyRule{Application: "app2"}},
+		}},
+	}}}
+	registration, err := tokendings.MakeClientRegistration(&signer, &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{key.Public()}}, app, jwker)
+	require.NoError(t, err)
+
+	t.Run("registering records the payload without calling the server", func(t *testing.T) {
+		changes = changes[:0]
+		response, err := instance.RegisterClient(context.Background(), registration, nil)
+		require.NoError(t, err)
+		assert.Equal(t, app.String(), response.ClientName)
+		assert.Equal(t, 0, server.Requests(http.MethodPost))
+
+		require.Len(t, changes, 1)
+		assert.Equal(t, "Client", changes[0].Kind)
+		assert.Equal(t, "register", changes[0].Action)
+		assert.Contains(t, changes[0].Diff, dryrun.Difference{Path: ".jwks.keys[].kid", New: key.KeyID})
+		assert.Contains(t, changes[0].Diff, dryrun.Difference{Path: ".software_statement.accessPolicyInbound", New: "cluster1:team1:app2"})
+	})
+
+	t.Run("reading calls the server", func(t *testing.T) {
+		changes = changes[:0]
+		_, err := instance.ReadClient(context.Background(), app, nil)
+		assert.ErrorIs(t, err, tokendings.ErrNotFound)
+		assert.Equal(t, 1, server.Requests(http.MethodGet))
+		assert.Empty(t, changes)
+	})
+
+	t.Run("deleting records the client without calling the server", func(t *testing.T) {
+		changes = changes[:0]
+		require.NoError(t, instance.DeleteClient(context.Background(), app, nil))
+		assert.Equal(t, 0, server.Requests(http.MethodDelete))
+
+		require.Len(t, changes, 1)
+		assert.Equal(t, "delete", changes[0].Action)
+		assert.Equal(t, "cluster1:team1:app1 at "+server.URL, changes[0].Name)
+	})
+}
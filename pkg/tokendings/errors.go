@@ -0,0 +1,119 @@
+package tokendings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRetryable marks failures that may succeed if the request is repeated, e.g. 5xx, 429 or network errors.
+	ErrRetryable = fmt.Errorf("retryable")
+	// ErrPermanent marks failures that will not succeed until the request itself changes, e.g. 400 Bad Request.
+	ErrPermanent = fmt.Errorf("permanent")
+	// ErrUnauthorized marks failures caused by Tokendings rejecting jwker's credentials.
+	ErrUnauthorized = fmt.Errorf("unauthorized")
+	// ErrNotFound marks failures where Tokendings does not know the requested client.
+	ErrNotFound = fmt.Errorf("not found")
+	// ErrRegistrationMismatch marks registrations where Tokendings responded with a client that differs from the requested one.
+	ErrRegistrationMismatch = fmt.Errorf("registration mismatch")
+)
+
+// Error is returned for non-successful responses from Tokendings.
+type Error struct {
+	Operation  string
+	StatusCode int
+	Status     string
+	Body       string
+	// RetryAfter is the delay requested by Tokendings through the Retry-After header, if any.
+	RetryAfter time.Duration
+	kind       error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Operation, e.Status, e.Body)
+}
+
+func (e *Error) Unwrap() error {
+	return e.kind
+}
+
+func newResponseError(operation string, resp *http.Response, body []byte) *Error {
+	return &Error{
+		Operation:  operation,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       strings.TrimSpace(string(body)),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		kind:       classifyStatus(resp.StatusCode),
+	}
+}
+
+func classifyStatus(code int) error {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return ErrUnauthorized
+	case code == http.StatusNotFound:
+		return ErrNotFound
+	case code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500:
+		return ErrRetryable
+	default:
+		return ErrPermanent
+	}
+}
+
+// transportError wraps errors from the HTTP client itself. These are retryable unless the
+// caller's context is done, in which case retrying is pointless.
+func transportError(ctx context.Context, operation string, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	return fmt.Errorf("%s: %w: %w", operation, ErrRetryable, err)
+}
+
+// parseRetryAfter supports both forms allowed by RFC 9110: delay-seconds and HTTP-date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+// IsRetryable reports whether err is worth retrying. Authentication failures are not,
+// as retrying with the same credentials is rejected the same way.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrRetryable)
+}
+
+// IsPermanent reports whether err will keep failing until the request changes.
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrPermanent)
+}
+
+// RetryAfter returns the delay requested by Tokendings for err, or zero if none was given.
+func RetryAfter(err error) time.Duration {
+	var tdErr *Error
+	if errors.As(err, &tdErr) {
+		return tdErr.RetryAfter
+	}
+	return 0
+}
@@ -0,0 +1,36 @@
+package tokendings
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	for code, expected := range map[int]error{
+		http.StatusBadRequest:          ErrPermanent,
+		http.StatusConflict:            ErrPermanent,
+		http.StatusUnauthorized:        ErrUnauthorized,
+		http.StatusForbidden:           ErrUnauthorized,
+		http.StatusNotFound:            ErrNotFound,
+		http.StatusRequestTimeout:      ErrRetryable,
+		http.StatusTooManyRequests:     ErrRetryable,
+		http.StatusInternalServerError: ErrRetryable,
+		http.StatusServiceUnavailable:  ErrRetryable,
+	} {
+		assert.Equal(t, expected, classifyStatus(code), "status code %d", code)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-1", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("Wed, 01 Jan 2025 12:00:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Wed, 01 Jan 2025 11:59:00 GMT", now))
+}
@@ -0,0 +1,140 @@
+package tokendings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RegistrationPolicy decides whether a registration across several instances is successful enough to write the secret.
+type RegistrationPolicy string
+
+const (
+	// RegistrationPolicyAll requires every instance to succeed.
+	RegistrationPolicyAll RegistrationPolicy = "all"
+	// RegistrationPolicyQuorum requires a majority of the instances to succeed.
+	RegistrationPolicyQuorum RegistrationPolicy = "quorum"
+	// RegistrationPolicyPrimary requires the primary instance to succeed.
+	RegistrationPolicyPrimary RegistrationPolicy = "primary"
+)
+
+func ParseRegistrationPolicy(s string) (RegistrationPolicy, error) {
+	switch p := RegistrationPolicy(s); p {
+	case RegistrationPolicyAll, RegistrationPolicyQuorum, RegistrationPolicyPrimary:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown registration policy %q; must be one of %q, %q or %q", s, RegistrationPolicyAll, RegistrationPolicyQuorum, RegistrationPolicyPrimary)
+	}
+}
+
+// Satisfied reports whether results fulfil the policy, given the base URL of the primary instance.
+func (p RegistrationPolicy) Satisfied(results RegistrationResults, primary string) bool {
+	if len(results) == 0 {
+		return false
+	}
+
+	switch p {
+	case RegistrationPolicyQuorum:
+		return len(results)-len(results.Failed()) > len(results)/2
+	case RegistrationPolicyPrimary:
+		return results.Succeeded(primary)
+	default:
+		return len(results.Failed()) == 0
+	}
+}
+
+type RegistrationResult struct {
+	BaseURL string
+	// Response is the registration that Tokendings responded with, if any. It is set for mismatched registrations as well.
+	Response *ClientRegistrationResponse
+	// Drift is the drift found, and repaired, by SyncAll, if any.
+	Drift Drift
+	// Skipped is set for instances that were not called, as the client was already registered there unchanged; see Fingerprint.
+	Skipped  bool
+	Err      error
+	Duration time.Duration
+}
+
+type RegistrationResults []RegistrationResult
+
+func (r RegistrationResults) Failed() RegistrationResults {
+	failed := make(RegistrationResults, 0)
+	for _, result := range r {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Succeeded reports whether the registration with the instance at baseURL succeeded.
+func (r RegistrationResults) Succeeded(baseURL string) bool {
+	return slices.ContainsFunc(r, func(result RegistrationResult) bool {
+		return result.BaseURL == baseURL && result.Err == nil
+	})
+}
+
+// Err joins the errors of all failed results, or returns nil if every instance succeeded.
+func (r RegistrationResults) Err() error {
+	errs := make([]error, 0)
+	for _, result := range r.Failed() {
+		errs = append(errs, fmt.Errorf("registering client with Tokendings %q: %w", result.BaseURL, result.Err))
+	}
+	return errors.Join(errs...)
+}
+
+// RegisterAll registers the client with every instance, running at most parallelism registrations at a time.
+// Results are returned in the same order as instances, regardless of the order they completed in.
+// previous holds the state from the client's latest registration with each instance, keyed by base URL.
+func RegisterAll(ctx context.Context, instances []Instance, registration *ClientRegistration, previous map[string]RegistrationState, parallelism int) RegistrationResults {
+	return fanOut(instances, previous, parallelism, func(instance *Instance, state *RegistrationState) (*ClientRegistrationResponse, Drift, error) {
+		response, err := instance.RegisterClient(ctx, registration, state)
+		return response, nil, err
+	})
+}
+
+// SyncAll reads the client from every instance, and repairs any drift from registration; see Instance.SyncClient.
+// It runs and orders the results as RegisterAll.
+func SyncAll(ctx context.Context, instances []Instance, appClientId ClientID, registration *ClientRegistration, previous map[string]RegistrationState, parallelism int) RegistrationResults {
+	return fanOut(instances, previous, parallelism, func(instance *Instance, state *RegistrationState) (*ClientRegistrationResponse, Drift, error) {
+		return instance.SyncClient(ctx, appClientId, registration, state)
+	})
+}
+
+func fanOut(instances []Instance, previous map[string]RegistrationState, parallelism int, fn func(*Instance, *RegistrationState) (*ClientRegistrationResponse, Drift, error)) RegistrationResults {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	results := make(RegistrationResults, len(instances))
+	sem := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+	for i := range instances {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var state *RegistrationState
+			if s, ok := previous[instances[i].BaseURL]; ok {
+				state = &s
+			}
+
+			start := time.Now()
+			response, drift, err := fn(&instances[i], state)
+			results[i] = RegistrationResult{
+				BaseURL:  instances[i].BaseURL,
+				Response: response,
+				Drift:    drift,
+				Err:      err,
+				Duration: time.Since(start),
+			}
+		})
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,93 @@
+package tokendings
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nais/jwker/pkg/jwk"
+)
+
+func TestRegistrationPolicy_Satisfied(t *testing.T) {
+	ok := RegistrationResult{BaseURL: "ok"}
+	failed := RegistrationResult{BaseURL: "failed", Err: fmt.Errorf("boom")}
+
+	for _, tt := range []struct {
+		name    string
+		results RegistrationResults
+		all     bool
+		quorum  bool
+		primary bool
+	}{
+		{"no instances", RegistrationResults{}, false, false, false},
+		{"all succeeded", RegistrationResults{ok, ok, ok}, true, true, true},
+		{"primary failed", RegistrationResults{failed, ok, ok}, false, true, false},
+		{"secondary failed", RegistrationResults{ok, failed, ok}, false, true, true},
+		{"majority failed", RegistrationResults{ok, failed, failed}, false, false, true},
+		{"half failed", RegistrationResults{ok, failed}, false, false, true},
+		{"all failed", RegistrationResults{failed, failed}, false, false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := ""
+			if len(tt.results) > 0 {
+				primary = tt.results[0].BaseURL
+			}
+
+			assert.Equal(t, tt.all, RegistrationPolicyAll.Satisfied(tt.results, primary))
+			assert.Equal(t, tt.quorum, RegistrationPolicyQuorum.Satisfied(tt.results, primary))
+			assert.Equal(t, tt.primary, RegistrationPolicyPrimary.Satisfied(tt.results, primary))
+		})
+	}
+}
+
+func TestParseRegistrationPolicy(t *testing.T) {
+	policy, err := ParseRegistrationPolicy("quorum")
+	assert.NoError(t, err)
+	assert.Equal(t, RegistrationPolicyQuorum, policy)
+
+	_, err = ParseRegistrationPolicy("most")
+	assert.Error(t, err)
+}
+
+func TestRegisterAll(t *testing.T) {
+	key, err := jwk.Generate()
+	require.NoError(t, err)
+
+	healthy := httptest.NewServer(http.HandlerFunc(echoRegistration))
+	defer healthy.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer broken.Close()
+
+	instances := []Instance{
+		NewInstance(healthy.URL, "jwker", signingKeys(&key), metadata(healthy.URL), "", healthy.Client()),
+		NewInstance(broken.URL, "jwker", signingKeys(&key), metadata(broken.URL), "", broken.Client()),
+		NewInstance(healthy.URL, "jwker", signingKeys(&key), metadata(healthy.URL), "", healthy.Client()),
+	}
+
+	results := RegisterAll(context.Background(), instances, &ClientRegistration{
+		ClientName: "cluster1:team1:app1",
+		Jwks: jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{key.Public()},
+		},
+	}, nil, 2)
+
+	require.Len(t, results, 3)
+	assert.Equal(t, healthy.URL, results[0].BaseURL)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, "cluster1:team1:app1", results[0].Response.ClientName)
+	assert.Equal(t, broken.URL, results[1].BaseURL)
+	assert.True(t, IsPermanent(results[1].Err))
+	assert.NoError(t, results[2].Err)
+
+	assert.Len(t, results.Failed(), 1)
+	assert.ErrorContains(t, results.Err(), broken.URL)
+}
@@ -0,0 +1,45 @@
+package tokendings
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+// HTTPOptions configures the HTTP client used for requests to Tokendings.
+type HTTPOptions struct {
+	// Timeout is the upper bound for a single request, including reading the response body.
+	Timeout time.Duration
+	// IdleConnTimeout is how long an idle keep-alive connection is kept in the pool.
+	IdleConnTimeout time.Duration
+	// MaxIdleConnsPerHost is the number of idle keep-alive connections kept per Tokendings host.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the total number of connections per Tokendings host. Zero means no limit.
+	MaxConnsPerHost int
+	// TLS, if set, replaces Go's default TLS settings; see TLSOptions.
+	TLS *tls.Config
+}
+
+func DefaultHTTPOptions() HTTPOptions {
+	return HTTPOptions{
+		Timeout:             10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConnsPerHost: 20,
+	}
+}
+
+// NewHTTPClient returns a client that is safe to share between instances and concurrent reconciles.
+func NewHTTPClient(opts HTTPOptions) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.IdleConnTimeout = opts.IdleConnTimeout
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = opts.MaxConnsPerHost
+	if opts.TLS != nil {
+		transport.TLSClientConfig = opts.TLS
+	}
+
+	return &http.Client{
+		Timeout:   opts.Timeout,
+		Transport: transport,
+	}
+}
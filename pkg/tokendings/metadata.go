@@ -0,0 +1,226 @@
+package tokendings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nais/liberator/pkg/oauth"
+)
+
+// ErrMetadataUnresolved is returned for instances whose metadata has not been resolved yet, e.g. because the instance was unreachable at startup.
+var ErrMetadataUnresolved = fmt.Errorf("metadata has not been resolved")
+
+// Metadata holds the authorization server metadata of an instance.
+// It is shared between copies of the instance, so that a refresh is seen by all of them.
+type Metadata struct {
+	// HTTPClient is used to fetch the metadata, e.g. to apply the instance's TLS settings. Nil uses http.DefaultClient.
+	HTTPClient *http.Client
+
+	wellKnownURL string
+	current      atomic.Pointer[serverMetadata]
+	refreshing   sync.Mutex
+}
+
+// serverMetadata is the metadata that jwker uses; oauth holds the properties that are written to secrets.
+type serverMetadata struct {
+	oauth *oauth.MetadataOAuth
+	// registrationEndpoint is the RFC 7591 client registration endpoint, if the server has one.
+	registrationEndpoint string
+}
+
+// NewMetadata returns metadata that is resolved and refreshed from wellKnownURL. A nil metadata is unresolved.
+// An empty wellKnownURL gives metadata that is never resolved or refreshed.
+func NewMetadata(wellKnownURL string, metadata *oauth.MetadataOAuth) *Metadata {
+	m := &Metadata{wellKnownURL: wellKnownURL}
+	if metadata != nil {
+		m.current.Store(&serverMetadata{oauth: metadata})
+	}
+	return m
+}
+
+// Get returns the current metadata, or nil if there is none.
+func (m *Metadata) Get() *oauth.MetadataOAuth {
+	if m == nil {
+		return nil
+	}
+	if current := m.current.Load(); current != nil {
+		return current.oauth
+	}
+	return nil
+}
+
+// RegistrationEndpoint returns the RFC 7591 client registration endpoint from the current metadata, or an empty string if there is none.
+func (m *Metadata) RegistrationEndpoint() string {
+	if m == nil {
+		return ""
+	}
+	if current := m.current.Load(); current != nil {
+		return current.registrationEndpoint
+	}
+	return ""
+}
+
+// Resolved reports whether the metadata has been resolved.
+func (m *Metadata) Resolved() bool {
+	return m.Get() != nil
+}
+
+// Resolve fetches the metadata from the well-known URL, unless it has already been resolved.
+func (m *Metadata) Resolve(ctx context.Context) error {
+	if m == nil || m.wellKnownURL == "" {
+		return ErrMetadataUnresolved
+	}
+
+	m.refreshing.Lock()
+	defer m.refreshing.Unlock()
+
+	if m.current.Load() != nil {
+		return nil
+	}
+
+	fetched, err := m.fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("fetching metadata from %s: %w", m.wellKnownURL, err)
+	}
+	m.current.Store(fetched)
+	return nil
+}
+
+// Refresh fetches the metadata from the well-known URL. Unresolved metadata is left to Resolve.
+// If it has changed, apply is called with the previous and the fetched metadata, and the fetched metadata replaces
+// the current one only if apply succeeds. A failed apply is thus retried by the next refresh.
+// Refresh reports whether the metadata changed.
+func (m *Metadata) Refresh(ctx context.Context, apply func(previous, current *oauth.MetadataOAuth) error) (bool, error) {
+	if m == nil || m.wellKnownURL == "" {
+		return false, nil
+	}
+
+	m.refreshing.Lock()
+	defer m.refreshing.Unlock()
+
+	previous := m.current.Load()
+	if previous == nil {
+		return false, nil
+	}
+
+	fetched, err := m.fetch(ctx)
+	if err != nil {
+		return false, fmt.Errorf("fetching metadata from %s: %w", m.wellKnownURL, err)
+	}
+
+	if MetadataEqual(previous.oauth, fetched.oauth) {
+		// the registration endpoint is not written to secrets, and is replaced without applying anything
+		if previous.registrationEndpoint != fetched.registrationEndpoint {
+			m.current.Store(&serverMetadata{oauth: previous.oauth, registrationEndpoint: fetched.registrationEndpoint})
+		}
+		return false, nil
+	}
+
+	if err := apply(previous.oauth, fetched.oauth); err != nil {
+		return true, err
+	}
+	m.current.Store(fetched)
+	return true, nil
+}
+
+func (m *Metadata) fetch(ctx context.Context) (*serverMetadata, error) {
+	httpClient := m.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, m.wellKnownURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &oauth.MetadataOAuth{}
+	if err := json.Unmarshal(body, metadata); err != nil {
+		return nil, fmt.Errorf("decoding metadata: %w", err)
+	}
+	if metadata.Issuer == "" {
+		return nil, fmt.Errorf("metadata has no issuer")
+	}
+
+	var registration struct {
+		RegistrationEndpoint string `json:"registration_endpoint"`
+	}
+	if err := json.Unmarshal(body, &registration); err != nil {
+		return nil, fmt.Errorf("decoding metadata: %w", err)
+	}
+
+	return &serverMetadata{oauth: metadata, registrationEndpoint: registration.RegistrationEndpoint}, nil
+}
+
+// MetadataEqual reports whether a and b have the same values for the properties that jwker uses.
+func MetadataEqual(a, b *oauth.MetadataOAuth) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Issuer == b.Issuer && a.JwksURI == b.JwksURI && a.TokenEndpoint == b.TokenEndpoint
+}
+
+// Unresolved returns the base URLs of the instances whose metadata has not been resolved.
+func Unresolved(instances []Instance) []string {
+	unresolved := make([]string, 0)
+	for _, instance := range instances {
+		if !instance.Metadata.Resolved() {
+			unresolved = append(unresolved, instance.BaseURL)
+		}
+	}
+	return unresolved
+}
+
+// ResolveMetadata resolves the metadata of every unresolved instance, retrying with exponential backoff
+// until all of them are resolved or ctx is done. onError, if set, is called for every failed attempt.
+func ResolveMetadata(ctx context.Context, instances []Instance, initialBackoff, maxBackoff time.Duration, onError func(Instance, error)) error {
+	backoff := RetryOptions{InitialBackoff: initialBackoff, MaxBackoff: maxBackoff}
+
+	for attempt := 0; ; attempt++ {
+		remaining := 0
+		for _, instance := range instances {
+			if instance.Metadata.Resolved() {
+				continue
+			}
+			if err := instance.Metadata.Resolve(ctx); err != nil {
+				remaining++
+				if onError != nil {
+					onError(instance, err)
+				}
+			}
+		}
+		if remaining == 0 {
+			return nil
+		}
+
+		delay, _ := backoff.backoff(attempt, 0)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package tokendings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nais/liberator/pkg/oauth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata_Refresh(t *testing.T) {
+	var issuer atomic.Value
+	issuer.Store("https://tokendings.example.com")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&oauth.MetadataOAuth{
+			Issuer:        issuer.Load().(string),
+			JwksURI:       "https://tokendings.example.com/jwks",
+			TokenEndpoint: "https://tokendings.example.com/token",
+		})
+	}))
+	defer server.Close()
+
+	initial := &oauth.MetadataOAuth{
+		Issuer:        "https://tokendings.example.com",
+		JwksURI:       "https://tokendings.example.com/jwks",
+		TokenEndpoint: "https://tokendings.example.com/token",
+	}
+	m := NewMetadata(server.URL+oauth.WellKnownOAuthSuffix, initial)
+
+	applied := 0
+	apply := func(previous, current *oauth.MetadataOAuth) error {
+		applied++
+		assert.Equal(t, "https://tokendings.example.com", previous.Issuer)
+		assert.Equal(t, "https://tokenx.example.com", current.Issuer)
+		return nil
+	}
+
+	t.Run("unchanged metadata is not applied", func(t *testing.T) {
+		changed, err := m.Refresh(context.Background(), apply)
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, 0, applied)
+		assert.Same(t, initial, m.Get())
+	})
+
+	issuer.Store("https://tokenx.example.com")
+
+	t.Run("changed metadata is kept if apply fails", func(t *testing.T) {
+		changed, err := m.Refresh(context.Background(), func(previous, current *oauth.MetadataOAuth) error {
+			return fmt.Errorf("boom")
+		})
+		assert.Error(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, "https://tokendings.example.com", m.Get().Issuer)
+	})
+
+	t.Run("changed metadata is applied", func(t *testing.T) {
+		changed, err := m.Refresh(context.Background(), apply)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, 1, applied)
+		assert.Equal(t, "https://tokenx.example.com", m.Get().Issuer)
+	})
+
+	t.Run("static metadata is never refreshed", func(t *testing.T) {
+		static := NewMetadata("", initial)
+		changed, err := static.Refresh(context.Background(), apply)
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Same(t, initial, static.Get())
+	})
+
+	t.Run("nil metadata", func(t *testing.T) {
+		var missing *Metadata
+		assert.Nil(t, missing.Get())
+	})
+}
+
+func TestResolveMetadata(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(metadata("https://tokendings.example.com"))
+	}))
+	defer server.Close()
+
+	instances := []Instance{
+		{BaseURL: "http://resolved", Metadata: NewMetadata("", metadata("http://resolved"))},
+		{BaseURL: server.URL, Metadata: NewMetadata(server.URL+oauth.WellKnownOAuthSuffix, nil)},
+	}
+	assert.Equal(t, []string{server.URL}, Unresolved(instances))
+
+	failures := 0
+	err := ResolveMetadata(context.Background(), instances, time.Millisecond, 5*time.Millisecond, func(instance Instance, err error) {
+		assert.Equal(t, server.URL, instance.BaseURL)
+		failures++
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, failures)
+	assert.Empty(t, Unresolved(instances))
+	assert.Equal(t, "https://tokendings.example.com", instances[1].Metadata.Get().Issuer)
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		unreachable := []Instance{{BaseURL: "http://unreachable", Metadata: NewMetadata("http://127.0.0.1:0"+oauth.WellKnownOAuthSuffix, nil)}}
+		err := ResolveMetadata(ctx, unreachable, time.Millisecond, 5*time.Millisecond, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,37 @@
+package tokendings
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// pair is a key=value pair from a comma separated list in configuration, e.g. baseURL=backend.
+type pair struct {
+	key   string
+	value string
+}
+
+// parsePairs parses a comma separated list of key=value pairs, in order. The key is trimmed, and must be set and unique.
+// The value is returned as given. name describes a pair in errors, and form its syntax, e.g. "backend" and "baseURL=backend".
+func parsePairs(s, name, form string) ([]pair, error) {
+	pairs := make([]pair, 0)
+	for raw := range strings.SplitSeq(s, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(raw, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid %s %q; must be on the form %s", name, raw, form)
+		}
+		if slices.ContainsFunc(pairs, func(p pair) bool { return p.key == key }) {
+			return nil, fmt.Errorf("invalid %s %q; %s is given more than once", name, raw, key)
+		}
+
+		pairs = append(pairs, pair{key: key, value: value})
+	}
+	return pairs, nil
+}
@@ -0,0 +1,90 @@
+package tokendings
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// PrimaryStrategy decides which instance's metadata is written to an application's secret.
+type PrimaryStrategy string
+
+const (
+	// PrimaryStatic always selects the first configured instance.
+	PrimaryStatic PrimaryStrategy = "static"
+	// PrimaryFirstHealthy keeps the current primary while it is healthy, and otherwise selects the first healthy instance in configured order.
+	PrimaryFirstHealthy PrimaryStrategy = "first-healthy"
+	// PrimaryNamespace selects the instance mapped to the application's namespace, or the first configured instance if there is no mapping.
+	PrimaryNamespace PrimaryStrategy = "namespace"
+)
+
+func ParsePrimaryStrategy(s string) (PrimaryStrategy, error) {
+	switch p := PrimaryStrategy(s); p {
+	case PrimaryStatic, PrimaryFirstHealthy, PrimaryNamespace:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown primary strategy %q; must be one of %q, %q or %q", s, PrimaryStatic, PrimaryFirstHealthy, PrimaryNamespace)
+	}
+}
+
+// ParseNamespaceMapping parses a comma separated list of namespace=baseURL pairs.
+// Every base URL must be one of the given instances.
+func ParseNamespaceMapping(s string, instances []Instance) (map[string]string, error) {
+	pairs, err := parsePairs(s, "namespace mapping", "namespace=baseURL")
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		namespace, baseURL := p.key, strings.TrimSpace(p.value)
+		if baseURL == "" {
+			return nil, fmt.Errorf("invalid namespace mapping for %q; must be on the form namespace=baseURL", namespace)
+		}
+		if !slices.ContainsFunc(instances, func(i Instance) bool { return i.BaseURL == baseURL }) {
+			return nil, fmt.Errorf("namespace %q is mapped to %q, which is not a configured tokendings instance", namespace, baseURL)
+		}
+
+		mapping[namespace] = baseURL
+	}
+	return mapping, nil
+}
+
+type PrimarySelector struct {
+	Strategy PrimaryStrategy
+	// Namespaces maps namespaces to base URLs for PrimaryNamespace.
+	Namespaces map[string]string
+}
+
+// Select returns the primary instance for an application in namespace.
+// current is the base URL of the application's current primary, if any, and results are the outcomes of
+// the latest registration with each instance.
+func (p PrimarySelector) Select(instances []Instance, namespace, current string, results RegistrationResults) (Instance, error) {
+	if len(instances) == 0 {
+		return Instance{}, fmt.Errorf("no tokendings instances configured")
+	}
+
+	switch p.Strategy {
+	case PrimaryFirstHealthy:
+		healthy := func(i Instance) bool {
+			return i.CircuitBreaker.State() != CircuitOpen && results.Succeeded(i.BaseURL)
+		}
+
+		if idx := slices.IndexFunc(instances, func(i Instance) bool { return i.BaseURL == current }); idx >= 0 && healthy(instances[idx]) {
+			return instances[idx], nil
+		}
+		if idx := slices.IndexFunc(instances, healthy); idx >= 0 {
+			return instances[idx], nil
+		}
+		return Instance{}, fmt.Errorf("no healthy tokendings instance")
+	case PrimaryNamespace:
+		if baseURL, ok := p.Namespaces[namespace]; ok {
+			if idx := slices.IndexFunc(instances, func(i Instance) bool { return i.BaseURL == baseURL }); idx >= 0 {
+				return instances[idx], nil
+			}
+		}
+		return instances[0], nil
+	default:
+		return instances[0], nil
+	}
+}
@@ -0,0 +1,96 @@
+package tokendings
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrimarySelector_Select(t *testing.T) {
+	open := NewCircuitBreaker(DefaultCircuitBreakerOptions(), nil)
+	open.open()
+
+	instances := []Instance{
+		{BaseURL: "http://a"},
+		{BaseURL: "http://b"},
+		{BaseURL: "http://c"},
+	}
+	allOK := RegistrationResults{{BaseURL: "http://a"}, {BaseURL: "http://b"}, {BaseURL: "http://c"}}
+	aFailed := RegistrationResults{{BaseURL: "http://a", Err: fmt.Errorf("boom")}, {BaseURL: "http://b"}, {BaseURL: "http://c"}}
+
+	t.Run("static", func(t *testing.T) {
+		selector := PrimarySelector{Strategy: PrimaryStatic}
+
+		primary, err := selector.Select(instances, "team", "http://b", aFailed)
+		require.NoError(t, err)
+		assert.Equal(t, "http://a", primary.BaseURL)
+	})
+
+	t.Run("first-healthy", func(t *testing.T) {
+		selector := PrimarySelector{Strategy: PrimaryFirstHealthy}
+
+		primary, err := selector.Select(instances, "team", "", allOK)
+		require.NoError(t, err)
+		assert.Equal(t, "http://a", primary.BaseURL)
+
+		primary, err = selector.Select(instances, "team", "", aFailed)
+		require.NoError(t, err)
+		assert.Equal(t, "http://b", primary.BaseURL, "skips instances that failed")
+
+		primary, err = selector.Select(instances, "team", "http://c", allOK)
+		require.NoError(t, err)
+		assert.Equal(t, "http://c", primary.BaseURL, "keeps a healthy current primary")
+
+		withOpen := []Instance{{BaseURL: "http://a", CircuitBreaker: open}, instances[1], instances[2]}
+		primary, err = selector.Select(withOpen, "team", "http://a", allOK)
+		require.NoError(t, err)
+		assert.Equal(t, "http://b", primary.BaseURL, "skips instances with an open circuit breaker")
+
+		allFailed := RegistrationResults{{BaseURL: "http://a", Err: fmt.Errorf("boom")}}
+		_, err = selector.Select(instances, "team", "", allFailed)
+		assert.Error(t, err)
+	})
+
+	t.Run("namespace", func(t *testing.T) {
+		selector := PrimarySelector{Strategy: PrimaryNamespace, Namespaces: map[string]string{"team": "http://c"}}
+
+		primary, err := selector.Select(instances, "team", "", allOK)
+		require.NoError(t, err)
+		assert.Equal(t, "http://c", primary.BaseURL)
+
+		primary, err = selector.Select(instances, "other", "", allOK)
+		require.NoError(t, err)
+		assert.Equal(t, "http://a", primary.BaseURL, "unmapped namespaces use the first instance")
+	})
+
+	t.Run("no instances", func(t *testing.T) {
+		_, err := PrimarySelector{}.Select(nil, "team", "", nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestParseNamespaceMapping(t *testing.T) {
+	instances := []Instance{{BaseURL: "http://a"}, {BaseURL: "http://b"}}
+
+	mapping, err := ParseNamespaceMapping(" team-a=http://a, team-b=http://b,", instances)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team-a": "http://a", "team-b": "http://b"}, mapping)
+
+	mapping, err = ParseNamespaceMapping("", instances)
+	require.NoError(t, err)
+	assert.Empty(t, mapping)
+
+	_, err = ParseNamespaceMapping("team-a", instances)
+	assert.Error(t, err)
+
+	_, err = ParseNamespaceMapping("team-a=http://unknown", instances)
+	assert.Error(t, err)
+
+	_, err = ParseNamespaceMapping("team-a=", instances)
+	assert.Error(t, err)
+
+	_, err = ParseNamespaceMapping("team-a=http://a,team-a=http://b", instances)
+	assert.ErrorContains(t, err, "team-a is given more than once")
+}
@@ -0,0 +1,173 @@
+package tokendings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReadinessPolicy decides how many instances must be healthy for jwker to be ready.
+type ReadinessPolicy string
+
+const (
+	// ReadinessPolicyAny requires at least one healthy instance.
+	ReadinessPolicyAny ReadinessPolicy = "any"
+	// ReadinessPolicyAll requires every instance to be healthy.
+	ReadinessPolicyAll ReadinessPolicy = "all"
+)
+
+func ParseReadinessPolicy(s string) (ReadinessPolicy, error) {
+	switch p := ReadinessPolicy(s); p {
+	case ReadinessPolicyAny, ReadinessPolicyAll:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown readiness policy %q; must be one of %q or %q", s, ReadinessPolicyAny, ReadinessPolicyAll)
+	}
+}
+
+// Probe sends a lightweight authenticated request to the registration endpoint of the instance's backend, without registering
+// or changing anything. It fails if the instance is unreachable, unavailable or rejects jwker's credentials; other responses,
+// such as 405 Method Not Allowed, show that the instance is up and accepts jwker's credentials.
+func (t *Instance) Probe(ctx context.Context) error {
+	return t.backend().Probe(ctx, t)
+}
+
+// probeEndpoint probes endpoint with an authenticated GET; see Instance.Probe.
+func probeEndpoint(ctx context.Context, t *Instance, operation, endpoint string) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := t.getAccessToken(ctx, endpoint)
+	if err != nil {
+		return fmt.Errorf("unable to get token for invoking tokendings: %w", err)
+	}
+	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	resp, err := t.HTTPClient.Do(request)
+	if err != nil {
+		return transportError(ctx, operation, err)
+	}
+
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 400 {
+		return nil
+	}
+
+	respErr := newResponseError(operation, resp, body)
+	if errors.Is(respErr, ErrRetryable) || errors.Is(respErr, ErrUnauthorized) {
+		return respErr
+	}
+	return nil
+}
+
+// Prober periodically checks the health of every instance, so that readiness checks do not contact Tokendings themselves.
+// An instance is healthy if its metadata is resolved and its latest probe succeeded.
+type Prober struct {
+	Instances []Instance
+	Interval  time.Duration
+	Timeout   time.Duration
+	Policy    ReadinessPolicy
+	// OnProbe, if set, is called with the outcome of every probe.
+	OnProbe func(Instance, error)
+
+	mu      sync.Mutex
+	results map[string]error
+}
+
+// Start probes every instance until ctx is done. It implements manager.Runnable.
+func (p *Prober) Start(ctx context.Context) error {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.probe(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every replica reports its own readiness.
+func (p *Prober) NeedLeaderElection() bool {
+	return false
+}
+
+func (p *Prober) probe(ctx context.Context) {
+	results := make(map[string]error, len(p.Instances))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, instance := range p.Instances {
+		wg.Go(func() {
+			err := ErrMetadataUnresolved
+			if instance.Metadata.Resolved() {
+				err = p.probeInstance(ctx, instance)
+			}
+
+			if p.OnProbe != nil {
+				p.OnProbe(instance, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[instance.BaseURL] = err
+		})
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results = results
+}
+
+func (p *Prober) probeInstance(ctx context.Context, instance Instance) error {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	return instance.Probe(ctx)
+}
+
+// Check is a health check that fails unless enough instances are healthy according to the policy.
+func (p *Prober) Check(_ *http.Request) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.results == nil {
+		return fmt.Errorf("tokendings instances have not been probed yet")
+	}
+
+	unhealthy := make([]string, 0)
+	for _, instance := range p.Instances {
+		err, probed := p.results[instance.BaseURL]
+		switch {
+		case !probed:
+			unhealthy = append(unhealthy, fmt.Sprintf("%s: not probed yet", instance.BaseURL))
+		case err != nil:
+			unhealthy = append(unhealthy, fmt.Sprintf("%s: %s", instance.BaseURL, err))
+		}
+	}
+
+	healthy := len(p.Instances) - len(unhealthy)
+	switch {
+	case len(p.Instances) == 0:
+		return fmt.Errorf("no tokendings instances configured")
+	case p.Policy == ReadinessPolicyAny && healthy > 0:
+		return nil
+	case len(unhealthy) == 0:
+		return nil
+	}
+	return fmt.Errorf("unhealthy Tokendings instances: %s", strings.Join(unhealthy, "; "))
+}
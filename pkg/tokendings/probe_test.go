@@ -0,0 +1,96 @@
+package tokendings
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nais/jwker/pkg/jwk"
+)
+
+func TestProbe(t *testing.T) {
+	key, err := jwk.Generate()
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		status  int
+		healthy bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusMethodNotAllowed, true},
+		{http.StatusNotFound, true},
+		{http.StatusUnauthorized, false},
+		{http.StatusForbidden, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusServiceUnavailable, false},
+	} {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodGet, r.Method)
+				assert.Equal(t, "/registration/client", r.URL.Path)
+				assert.NotEmpty(t, r.Header.Get("Authorization"))
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			instance := NewInstance(server.URL, "jwker", signingKeys(&key), metadata(server.URL), "", server.Client())
+			err := instance.Probe(context.Background())
+			if tt.healthy {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestProber(t *testing.T) {
+	key, err := jwk.Generate()
+	require.NoError(t, err)
+
+	var healthy atomic.Bool
+	healthy.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer server.Close()
+
+	instances := []Instance{
+		NewInstance(server.URL, "jwker", signingKeys(&key), metadata(server.URL), "", server.Client()),
+		NewInstance("http://unresolved", "jwker", signingKeys(&key), nil, "", server.Client()),
+	}
+
+	var probed atomic.Int32
+	anyHealthy := &Prober{Instances: instances, Policy: ReadinessPolicyAny, OnProbe: func(Instance, error) { probed.Add(1) }}
+	allHealthy := &Prober{Instances: instances, Policy: ReadinessPolicyAll}
+
+	assert.ErrorContains(t, anyHealthy.Check(nil), "not been probed yet")
+
+	anyHealthy.probe(context.Background())
+	allHealthy.probe(context.Background())
+	assert.Equal(t, int32(2), probed.Load())
+	assert.NoError(t, anyHealthy.Check(nil))
+	assert.ErrorContains(t, allHealthy.Check(nil), "http://unresolved: metadata has not been resolved")
+
+	healthy.Store(false)
+	anyHealthy.probe(context.Background())
+	assert.ErrorContains(t, anyHealthy.Check(nil), server.URL)
+}
+
+func TestParseReadinessPolicy(t *testing.T) {
+	p, err := ParseReadinessPolicy("all")
+	assert.NoError(t, err)
+	assert.Equal(t, ReadinessPolicyAll, p)
+
+	_, err = ParseReadinessPolicy("some")
+	assert.Error(t, err)
+}
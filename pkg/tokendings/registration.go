@@ -1,18 +1,19 @@
 package tokendings
 
 import (
-	"bytes"
 	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"net/url"
-	"os"
+	"slices"
 	"strings"
 
 	"github.com/go-jose/go-jose/v4"
 	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/nais/jwker/pkg/jwk"
 	v1 "github.com/nais/liberator/pkg/apis/nais.io/v1"
 	"github.com/nais/liberator/pkg/oauth"
 )
@@ -37,124 +38,238 @@ type ClientRegistrationResponse struct {
 	ClientRegistration
 	GrantTypes              []string `json:"grant_types"`
 	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	// ClientID, RegistrationAccessToken and RegistrationClientURI are assigned by servers that speak RFC 7591; Tokendings leaves them empty.
+	ClientID                string `json:"client_id,omitempty"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
 }
 
-type SoftwareStatement struct {
-	AppId                string   `json:"appId"`
-	AccessPolicyInbound  []string `json:"accessPolicyInbound"`
-	AccessPolicyOutbound []string `json:"accessPolicyOutbound"`
+// State returns the state needed to manage the registered client later, or nil if the server assigned none.
+func (r *ClientRegistrationResponse) State() *RegistrationState {
+	if r == nil || (r.ClientID == "" && r.RegistrationAccessToken == "" && r.RegistrationClientURI == "") {
+		return nil
+	}
+	return &RegistrationState{
+		ClientID:                r.ClientID,
+		RegistrationAccessToken: r.RegistrationAccessToken,
+		RegistrationClientURI:   r.RegistrationClientURI,
+	}
 }
 
-type Instance struct {
-	BaseURL       string
-	ClientID      string
-	ClientJwk     *jose.JSONWebKey
-	Metadata      *oauth.MetadataOAuth
-	AuthTokenPath string // optional: path to service account token file
+const (
+	// TokenExchangeGrantType is the grant type that Tokendings clients must be registered with.
+	TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// PrivateKeyJwtAuthMethod is the token endpoint authentication method that Tokendings clients must be registered with.
+	PrivateKeyJwtAuthMethod = "private_key_jwt"
+)
+
+// Verify checks that the response describes the client that was requested: the same client name and keys,
+// the token exchange grant type and authentication with a private key JWT.
+func (r *ClientRegistrationResponse) Verify(registration *ClientRegistration) error {
+	if mismatches := r.mismatches(registration); len(mismatches) > 0 {
+		return fmt.Errorf("%w: %s", ErrRegistrationMismatch, strings.Join(mismatches, "; "))
+	}
+	return nil
 }
 
-func NewInstance(baseURL, clientID string, clientJwk *jose.JSONWebKey, metadata *oauth.MetadataOAuth, authTokenPath string) Instance {
-	return Instance{
-		BaseURL:       baseURL,
-		ClientID:      clientID,
-		ClientJwk:     clientJwk,
-		Metadata:      metadata,
-		AuthTokenPath: authTokenPath,
+func (r *ClientRegistrationResponse) mismatches(registration *ClientRegistration) []string {
+	mismatches := make([]string, 0)
+
+	if r.ClientName != registration.ClientName {
+		mismatches = append(mismatches, fmt.Sprintf("client name is %q, expected %q", r.ClientName, registration.ClientName))
+	}
+	if !sameKeySet(r.Jwks, registration.Jwks) {
+		mismatches = append(mismatches, fmt.Sprintf("JWKS has key IDs %v, expected %v", keyIDs(r.Jwks), keyIDs(registration.Jwks)))
 	}
+	return append(mismatches, r.grantMismatches()...)
 }
 
-func (t *Instance) getAccessToken(endpoint string) (string, error) {
-	if t.AuthTokenPath != "" {
-		token, err := os.ReadFile(t.AuthTokenPath)
-		if err != nil {
-			return "", fmt.Errorf("unable to read token from %s: %w", t.AuthTokenPath, err)
-		}
-		accessToken := strings.TrimSpace(string(token))
-		if accessToken == "" {
-			return "", fmt.Errorf("token file %s is empty", t.AuthTokenPath)
-		}
-		return accessToken, nil
+// grantMismatches describes how the grant types and authentication method differ from those Tokendings clients must have.
+func (r *ClientRegistrationResponse) grantMismatches() []string {
+	mismatches := make([]string, 0)
+
+	if !slices.Contains(r.GrantTypes, TokenExchangeGrantType) {
+		mismatches = append(mismatches, fmt.Sprintf("grant types are %v, expected %q", r.GrantTypes, TokenExchangeGrantType))
+	}
+	if r.TokenEndpointAuthMethod != PrivateKeyJwtAuthMethod {
+		mismatches = append(mismatches, fmt.Sprintf("token endpoint auth method is %q, expected %q", r.TokenEndpointAuthMethod, PrivateKeyJwtAuthMethod))
 	}
-	return ClientAssertion(t.ClientJwk, t.ClientID, endpoint)
+	return mismatches
 }
 
-func (t *Instance) RegisterClient(registration *ClientRegistration) error {
-	endpoint := fmt.Sprintf("%s/registration/client", t.BaseURL)
+// sameKeySet reports whether a and b contain the same keys, regardless of order, compared by key ID and thumbprint.
+func sameKeySet(a, b jose.JSONWebKeySet) bool {
+	if len(a.Keys) != len(b.Keys) {
+		return false
+	}
 
-	data, err := json.Marshal(registration)
-	if err != nil {
-		return err
+	thumbprints := make(map[string]string, len(a.Keys))
+	for _, key := range a.Keys {
+		thumbprint, err := key.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return false
+		}
+		thumbprints[key.KeyID] = string(thumbprint)
 	}
 
-	request, err := http.NewRequest("POST", endpoint, bytes.NewReader(data))
-	if err != nil {
-		return err
+	for _, key := range b.Keys {
+		thumbprint, err := key.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return false
+		}
+		if expected, ok := thumbprints[key.KeyID]; !ok || expected != string(thumbprint) {
+			return false
+		}
 	}
-	request.Header.Add("Content-Type", "application/json")
+	return true
+}
 
-	accessToken, err := t.getAccessToken(endpoint)
-	if err != nil {
-		return fmt.Errorf("unable to get token for invoking tokendings: %w", err)
+func keyIDs(set jose.JSONWebKeySet) []string {
+	ids := make([]string, len(set.Keys))
+	for i, key := range set.Keys {
+		ids[i] = key.KeyID
 	}
+	return ids
+}
 
-	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+type SoftwareStatement struct {
+	AppId                string   `json:"appId"`
+	AccessPolicyInbound  []string `json:"accessPolicyInbound"`
+	AccessPolicyOutbound []string `json:"accessPolicyOutbound"`
+}
 
-	client := http.Client{}
-	resp, err := client.Do(request)
-	if err != nil {
-		return err
+type Instance struct {
+	BaseURL  string
+	ClientID string
+	// ClientKeys holds jwker's signing keys, and is shared between instances so that a reloaded key is used by all of them.
+	ClientKeys *jwk.SigningKeyStore
+	Metadata   *Metadata
+	// Authenticator provides the token jwker presents to the instance. A nil authenticator signs client assertions with ClientKeys.
+	Authenticator Authenticator
+	// Backend is the registration protocol spoken by the instance. A nil backend speaks Tokendings' own protocol.
+	Backend    Backend
+	HTTPClient *http.Client
+	Retry      RetryOptions
+	// CircuitBreaker is shared between copies of the instance. A nil breaker never opens.
+	CircuitBreaker *CircuitBreaker
+	// ReadSupport is shared between copies of the instance. A nil value never learns that reads are supported.
+	ReadSupport *ReadSupport
+}
+
+// NewInstance returns an instance that authenticates with the service account token at authTokenPath, or with client assertions if it is empty.
+func NewInstance(baseURL, clientID string, clientKeys *jwk.SigningKeyStore, metadata *oauth.MetadataOAuth, authTokenPath string, httpClient *http.Client) Instance {
+	if httpClient == nil {
+		httpClient = NewHTTPClient(DefaultHTTPOptions())
 	}
 
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusCreated {
-		response, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unable to register application with tokendings: %s: %s", resp.Status, response)
+	var authenticator Authenticator = &ClientAssertionSigner{ClientID: clientID, Keys: clientKeys}
+	if authTokenPath != "" {
+		authenticator = NewServiceAccountToken(authTokenPath, nil)
 	}
 
-	return nil
+	return Instance{
+		BaseURL:        baseURL,
+		ClientID:       clientID,
+		ClientKeys:     clientKeys,
+		Metadata:       NewMetadata("", metadata),
+		Authenticator:  authenticator,
+		HTTPClient:     httpClient,
+		Retry:          DefaultRetryOptions(),
+		CircuitBreaker: NewCircuitBreaker(DefaultCircuitBreakerOptions(), nil),
+		ReadSupport:    &ReadSupport{},
+	}
 }
 
-func (t *Instance) DeleteClient(ctx context.Context, appClientId ClientID) error {
-	endpoint := fmt.Sprintf("%s/registration/client", t.BaseURL)
+func (t *Instance) getAccessToken(ctx context.Context, endpoint string) (string, error) {
+	if t.Authenticator == nil {
+		return ClientAssertion(t.ClientKeys.Active(), t.ClientID, endpoint)
+	}
+	return t.Authenticator.Token(ctx, endpoint)
+}
 
-	request, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/%s", endpoint, url.QueryEscape(appClientId.String())), nil)
+// RegisterClient registers the client with the instance's backend, and returns the registration that the server responded with.
+// previous is the state from the client's latest registration with the instance, if any.
+// A response that does not match the registration returns an error wrapping ErrRegistrationMismatch, along with the response.
+func (t *Instance) RegisterClient(ctx context.Context, registration *ClientRegistration, previous *RegistrationState) (*ClientRegistrationResponse, error) {
+	var response *ClientRegistrationResponse
+	err := t.Retry.retry(ctx, func() error {
+		return t.CircuitBreaker.call(func() error {
+			var err error
+			response, err = t.backend().Register(ctx, t, registration, previous)
+			return err
+		})
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	accessToken, err := t.getAccessToken(endpoint)
-	if err != nil {
-		return fmt.Errorf("unable to get token for invoking tokendings: %w", err)
+	if err := response.Verify(registration); err != nil {
+		return response, fmt.Errorf("registered with tokendings: %w", err)
 	}
+	return response, nil
+}
+
+// ReadClient returns the client as registered with the instance's backend. previous is as for RegisterClient.
+// Reading a client that does not exist returns an error wrapping ErrNotFound.
+func (t *Instance) ReadClient(ctx context.Context, appClientId ClientID, previous *RegistrationState) (*ClientRegistrationResponse, error) {
+	var response *ClientRegistrationResponse
+	err := t.Retry.retry(ctx, func() error {
+		return t.CircuitBreaker.call(func() error {
+			var err error
+			response, err = t.backend().Read(ctx, t, appClientId, previous)
+			return err
+		})
+	})
+	if err == nil {
+		t.ReadSupport.observe()
+	}
+	return response, err
+}
 
-	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	client := http.Client{}
-	resp, err := client.Do(request)
+// UpdateClient replaces the registration of an existing client with the instance's backend. previous is as for RegisterClient.
+// Updating a client that does not exist returns an error wrapping ErrNotFound. The response is verified as for RegisterClient.
+func (t *Instance) UpdateClient(ctx context.Context, appClientId ClientID, registration *ClientRegistration, previous *RegistrationState) (*ClientRegistrationResponse, error) {
+	var response *ClientRegistrationResponse
+	err := t.Retry.retry(ctx, func() error {
+		return t.CircuitBreaker.call(func() error {
+			var err error
+			response, err = t.backend().Update(ctx, t, appClientId, registration, previous)
+			return err
+		})
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusNoContent {
-		return nil
+	if err := response.Verify(registration); err != nil {
+		return response, fmt.Errorf("updated in tokendings: %w", err)
 	}
+	return response, nil
+}
 
-	msg, _ := io.ReadAll(resp.Body)
+// DeleteClient removes the client from the instance's backend. previous is as for RegisterClient.
+// Deleting a client that does not exist returns an error wrapping ErrNotFound.
+func (t *Instance) DeleteClient(ctx context.Context, appClientId ClientID, previous *RegistrationState) error {
+	return t.Retry.retry(ctx, func() error {
+		return t.CircuitBreaker.call(func() error {
+			return t.backend().Delete(ctx, t, appClientId, previous)
+		})
+	})
+}
 
-	return fmt.Errorf("delete client from tokendings: %s: %s", resp.Status, msg)
+func (t *Instance) backend() Backend {
+	if t.Backend == nil {
+		return TokendingsBackend{}
+	}
+	return t.Backend
 }
 
 func MakeClientRegistration(jwkerPrivateJwk *jose.JSONWebKey, clientPublicJwks *jose.JSONWebKeySet, appClientId ClientID, jwker v1.Jwker) (*ClientRegistration, error) {
-	key := jose.SigningKey{Algorithm: jose.RS256, Key: jwkerPrivateJwk.Key}
-	signerOpts := jose.SignerOptions{}
-	signerOpts.WithType("JWT")
-	signerOpts.WithHeader("kid", jwkerPrivateJwk.KeyID)
-
-	rsaSigner, err := jose.NewSigner(key, &signerOpts)
+	signer, err := jwk.NewSigner(jwkerPrivateJwk)
 	if err != nil {
 		return nil, err
 	}
-	builder := jwt.Signed(rsaSigner)
+	builder := jwt.Signed(signer)
 
 	softwareStatement, err := createSoftwareStatement(jwker, appClientId)
 	if err != nil {
@@ -174,6 +289,40 @@ func MakeClientRegistration(jwkerPrivateJwk *jose.JSONWebKey, clientPublicJwks *
 	}, nil
 }
 
+// Fingerprint returns a stable digest of the registration that MakeClientRegistration would make: the client name,
+// the IDs of the client's public keys, and the inbound and outbound access policy, regardless of order and duplicates.
+// Registrations with the same fingerprint are equivalent to Tokendings, so the client need not be registered again.
+func Fingerprint(appClientId ClientID, clientPublicJwks *jose.JSONWebKeySet, jwker v1.Jwker) (string, error) {
+	statement, err := createSoftwareStatement(jwker, appClientId)
+	if err != nil {
+		return "", err
+	}
+
+	normalize := func(values []string) []string {
+		normalized := slices.Clone(values)
+		slices.Sort(normalized)
+		return slices.Compact(normalized)
+	}
+
+	data, err := json.Marshal(struct {
+		ClientName           string   `json:"clientName"`
+		KeyIDs               []string `json:"keyIDs"`
+		AccessPolicyInbound  []string `json:"accessPolicyInbound"`
+		AccessPolicyOutbound []string `json:"accessPolicyOutbound"`
+	}{
+		ClientName:           appClientId.String(),
+		KeyIDs:               normalize(keyIDs(*clientPublicJwks)),
+		AccessPolicyInbound:  normalize(statement.AccessPolicyInbound),
+		AccessPolicyOutbound: normalize(statement.AccessPolicyOutbound),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
 func createSoftwareStatement(jwker v1.Jwker, appId ClientID) (*SoftwareStatement, error) {
 	inbound := make([]string, 0)
 	outbound := make([]string, 0)
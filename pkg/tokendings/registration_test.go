@@ -2,14 +2,18 @@ package tokendings
 
 import (
 	"context"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-jose/go-jose/v4"
 	"github.com/go-jose/go-jose/v4/jwt"
@@ -88,13 +92,13 @@ func TestDeleteClient(t *testing.T) {
 	}))
 	defer server.Close()
 
-	td := NewInstance(server.URL, "jwker", &jwk, metadata(server.URL), authTokenPath)
+	td := NewInstance(server.URL, "jwker", signingKeys(&jwk), metadata(server.URL), authTokenPath, server.Client())
 
 	err = td.DeleteClient(context.Background(), ClientID{
 		Name:      "app1",
 		Namespace: "team1",
 		Cluster:   "cluster1",
-	})
+	}, nil)
 	assert.NoError(t, err)
 }
 
@@ -137,12 +141,12 @@ func TestRegisterClient(t *testing.T) {
 		assert.Equal(t, 1, len(clientRegistration.Jwks.Keys))
 		assert.Equal(t, "signedstatement", clientRegistration.SoftwareStatement)
 
-		w.WriteHeader(http.StatusCreated)
+		respondRegistered(w, clientRegistration)
 	}))
 	defer server.Close()
 
-	td := NewInstance(server.URL, "jwker", &jwk, metadata(server.URL), authTokenPath)
-	err = td.RegisterClient(&ClientRegistration{
+	td := NewInstance(server.URL, "jwker", signingKeys(&jwk), metadata(server.URL), authTokenPath, server.Client())
+	response, err := td.RegisterClient(context.Background(), &ClientRegistration{
 		ClientName: app.String(),
 		Jwks: jose.JSONWebKeySet{
 			Keys: []jose.JSONWebKey{
@@ -150,9 +154,12 @@ func TestRegisterClient(t *testing.T) {
 			},
 		},
 		SoftwareStatement: "signedstatement",
-	})
+	}, nil)
 
 	assert.NoError(t, err)
+	assert.Equal(t, app.String(), response.ClientName)
+	assert.Equal(t, []string{TokenExchangeGrantType}, response.GrantTypes)
+	assert.Equal(t, PrivateKeyJwtAuthMethod, response.TokenEndpointAuthMethod)
 }
 
 func TestRegisterClient_ClientAssertionFallback(t *testing.T) {
@@ -171,23 +178,213 @@ func TestRegisterClient_ClientAssertionFallback(t *testing.T) {
 
 		verifyToken(t, r, jwk)
 
-		w.WriteHeader(http.StatusCreated)
+		echoRegistration(w, r)
 	}))
 	defer server.Close()
 
 	// Empty AuthTokenPath → should fall back to ClientAssertion
-	td := NewInstance(server.URL, "jwker", &jwk, metadata(server.URL), "")
-	err = td.RegisterClient(&ClientRegistration{
+	td := NewInstance(server.URL, "jwker", signingKeys(&jwk), metadata(server.URL), "", server.Client())
+	_, err = td.RegisterClient(context.Background(), &ClientRegistration{
 		ClientName: app.String(),
 		Jwks: jose.JSONWebKeySet{
 			Keys: []jose.JSONWebKey{jwk},
 		},
 		SoftwareStatement: "signedstatement",
-	})
+	}, nil)
 
 	assert.NoError(t, err)
 }
 
+func TestRegisterClient_ContextCanceled(t *testing.T) {
+	jwk, err := jwk.Generate()
+	assert.NoError(t, err)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	td := NewInstance(server.URL, "jwker", signingKeys(&jwk), metadata(server.URL), "", server.Client())
+	_, err = td.RegisterClient(ctx, &ClientRegistration{
+		ClientName: "cluster1:team1:app1",
+		Jwks: jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{jwk},
+		},
+		SoftwareStatement: "signedstatement",
+	}, nil)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRegisterClient_Retry(t *testing.T) {
+	jwk, err := jwk.Generate()
+	assert.NoError(t, err)
+
+	registration := &ClientRegistration{
+		ClientName: "cluster1:team1:app1",
+		Jwks: jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{jwk},
+		},
+		SoftwareStatement: "signedstatement",
+	}
+
+	t.Run("retryable errors are retried until success", func(t *testing.T) {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			echoRegistration(w, r)
+		}))
+		defer server.Close()
+
+		td := NewInstance(server.URL, "jwker", signingKeys(&jwk), metadata(server.URL), "", server.Client())
+		td.Retry = RetryOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+		_, err := td.RegisterClient(context.Background(), registration, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("permanent errors are not retried", func(t *testing.T) {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("invalid access policy"))
+		}))
+		defer server.Close()
+
+		td := NewInstance(server.URL, "jwker", signingKeys(&jwk), metadata(server.URL), "", server.Client())
+		td.Retry = RetryOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+		_, err := td.RegisterClient(context.Background(), registration, nil)
+		assert.True(t, IsPermanent(err))
+		assert.ErrorContains(t, err, "invalid access policy")
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("unauthorized responses are not retried", func(t *testing.T) {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		td := NewInstance(server.URL, "jwker", signingKeys(&jwk), metadata(server.URL), "", server.Client())
+		td.Retry = RetryOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+		td.CircuitBreaker = NewCircuitBreaker(CircuitBreakerOptions{FailureThreshold: 1, OpenDuration: time.Minute}, nil)
+
+		_, err := td.RegisterClient(context.Background(), registration, nil)
+		assert.ErrorIs(t, err, ErrUnauthorized)
+		assert.False(t, IsRetryable(err))
+		assert.Equal(t, 1, attempts)
+		assert.Equal(t, CircuitClosed, td.CircuitBreaker.State())
+	})
+
+	t.Run("retry-after exceeding max backoff is returned to the caller", func(t *testing.T) {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		td := NewInstance(server.URL, "jwker", signingKeys(&jwk), metadata(server.URL), "", server.Client())
+		td.Retry = RetryOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+		_, err := td.RegisterClient(context.Background(), registration, nil)
+		assert.True(t, IsRetryable(err))
+		assert.Equal(t, 120*time.Second, RetryAfter(err))
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestRegisterClient_Mismatch(t *testing.T) {
+	key, err := jwk.Generate()
+	require.NoError(t, err)
+	other, err := jwk.Generate()
+	require.NoError(t, err)
+
+	registration := &ClientRegistration{
+		ClientName:        "cluster1:team1:app1",
+		Jwks:              jose.JSONWebKeySet{Keys: []jose.JSONWebKey{key.Public()}},
+		SoftwareStatement: "signedstatement",
+	}
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		respondRegistered(w, ClientRegistration{
+			ClientName: "cluster1:team1:app1",
+			Jwks:       jose.JSONWebKeySet{Keys: []jose.JSONWebKey{other.Public()}},
+		})
+	}))
+	defer server.Close()
+
+	td := NewInstance(server.URL, "jwker", signingKeys(&key), metadata(server.URL), "", server.Client())
+	td.Retry = RetryOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+	response, err := td.RegisterClient(context.Background(), registration, nil)
+	assert.ErrorIs(t, err, ErrRegistrationMismatch)
+	assert.ErrorContains(t, err, other.KeyID)
+	assert.False(t, IsRetryable(err))
+	assert.Equal(t, 1, attempts)
+	require.NotNil(t, response)
+	assert.Equal(t, other.KeyID, response.Jwks.Keys[0].KeyID)
+}
+
+func TestClientRegistrationResponse_Verify(t *testing.T) {
+	key, err := jwk.Generate()
+	require.NoError(t, err)
+	rotated, err := jwk.Generate()
+	require.NoError(t, err)
+
+	registration := &ClientRegistration{
+		ClientName: "cluster1:team1:app1",
+		Jwks:       jose.JSONWebKeySet{Keys: []jose.JSONWebKey{key.Public(), rotated.Public()}},
+	}
+	valid := func() ClientRegistrationResponse {
+		return ClientRegistrationResponse{
+			ClientRegistration: ClientRegistration{
+				ClientName: "cluster1:team1:app1",
+				Jwks:       jose.JSONWebKeySet{Keys: []jose.JSONWebKey{rotated.Public(), key.Public()}},
+			},
+			GrantTypes:              []string{TokenExchangeGrantType},
+			TokenEndpointAuthMethod: PrivateKeyJwtAuthMethod,
+		}
+	}
+
+	response := valid()
+	assert.NoError(t, response.Verify(registration))
+
+	for name, mutate := range map[string]func(*ClientRegistrationResponse){
+		"client name": func(r *ClientRegistrationResponse) { r.ClientName = "cluster1:team1:other" },
+		"missing key": func(r *ClientRegistrationResponse) { r.Jwks.Keys = r.Jwks.Keys[:1] },
+		"key with same ID": func(r *ClientRegistrationResponse) {
+			r.Jwks.Keys[0] = jose.JSONWebKey{Key: key.Public().Key, KeyID: rotated.KeyID}
+		},
+		"grant types": func(r *ClientRegistrationResponse) { r.GrantTypes = []string{"client_credentials"} },
+		"auth method": func(r *ClientRegistrationResponse) { r.TokenEndpointAuthMethod = "client_secret_basic" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			response := valid()
+			mutate(&response)
+			assert.ErrorIs(t, response.Verify(registration), ErrRegistrationMismatch)
+		})
+	}
+}
+
 func TestMakeClientRegistration(t *testing.T) {
 	signkey, err := jwk.Generate()
 	if err != nil {
@@ -228,6 +425,83 @@ func TestMakeClientRegistration(t *testing.T) {
 	assert.Equal(t, test.softwareStatement, string(js))
 }
 
+func TestMakeClientRegistration_EdDSA(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(cryptorand.Reader)
+	require.NoError(t, err)
+	signkey := jose.JSONWebKey{Key: privateKey, KeyID: "jwker-ed25519", Use: "sig"}
+
+	appkey, err := jwk.Generate()
+	require.NoError(t, err)
+	keyset := jwk.NewRotatedKeySet(appkey, jose.JSONWebKeySet{})
+
+	output, err := MakeClientRegistration(&signkey, &keyset.PublicKeys, ClientID{
+		Name:      "myapplication",
+		Namespace: "mynamespace",
+		Cluster:   "mycluster",
+	}, test.input)
+	require.NoError(t, err)
+
+	tok, err := jwt.ParseSigned(output.SoftwareStatement, []jose.SignatureAlgorithm{jose.EdDSA})
+	require.NoError(t, err)
+	assert.Equal(t, "jwker-ed25519", tok.Headers[0].KeyID)
+
+	claims := make(map[string]any)
+	assert.NoError(t, tok.Claims(signkey.Public(), &claims))
+}
+
+func TestFingerprint(t *testing.T) {
+	appkey, err := jwk.Generate()
+	require.NoError(t, err)
+	keyset := jwk.NewRotatedKeySet(appkey, jose.JSONWebKeySet{})
+
+	clientID := ClientID{Name: "myapplication", Namespace: "mynamespace", Cluster: "mycluster"}
+	fingerprint, err := Fingerprint(clientID, &keyset.PublicKeys, test.input)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(fingerprint, "sha256:"))
+
+	withInboundRules := func(rules []jwkerv1.AccessPolicyInboundRule) jwkerv1.Jwker {
+		jwker := test.input
+		jwker.Spec.AccessPolicy = &jwkerv1.AccessPolicy{Inbound: &jwkerv1.AccessPolicyInbound{Rules: rules}}
+		return jwker
+	}
+
+	rules := slices.Clone(test.input.Spec.AccessPolicy.Inbound.Rules)
+	slices.Reverse(rules)
+	reordered := withInboundRules(append(rules, rules[0]))
+	keys := keyset.PublicKeys
+	keys.Keys = append(slices.Clone(keys.Keys), keys.Keys[0])
+
+	t.Run("same for reordered and duplicated keys and rules", func(t *testing.T) {
+		actual, err := Fingerprint(clientID, &keys, reordered)
+		require.NoError(t, err)
+		assert.Equal(t, fingerprint, actual)
+	})
+
+	t.Run("changed by keys", func(t *testing.T) {
+		otherkey, err := jwk.Generate()
+		require.NoError(t, err)
+		rotated := jwk.NewRotatedKeySet(otherkey, keyset.PublicKeys)
+
+		actual, err := Fingerprint(clientID, &rotated.PublicKeys, test.input)
+		require.NoError(t, err)
+		assert.NotEqual(t, fingerprint, actual)
+	})
+
+	t.Run("changed by access policy", func(t *testing.T) {
+		changed := withInboundRules(test.input.Spec.AccessPolicy.Inbound.Rules[1:])
+
+		actual, err := Fingerprint(clientID, &keyset.PublicKeys, changed)
+		require.NoError(t, err)
+		assert.NotEqual(t, fingerprint, actual)
+	})
+
+	t.Run("changed by client name", func(t *testing.T) {
+		actual, err := Fingerprint(ClientID{Name: "otherapplication", Namespace: "mynamespace", Cluster: "mycluster"}, &keyset.PublicKeys, test.input)
+		require.NoError(t, err)
+		assert.NotEqual(t, fingerprint, actual)
+	})
+}
+
 func verifyToken(t *testing.T, r *http.Request, jwk jose.JSONWebKey) {
 	raw := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
 	sign, err := jose.ParseSignedCompact(raw, []jose.SignatureAlgorithm{jose.RS256})
@@ -247,6 +521,30 @@ func verifyToken(t *testing.T, r *http.Request, jwk jose.JSONWebKey) {
 	assert.Equal(t, "/registration/client", aud.Path)
 }
 
+// echoRegistration responds like Tokendings to a successful registration, echoing the requested client.
+func echoRegistration(w http.ResponseWriter, r *http.Request) {
+	var registration ClientRegistration
+	if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	respondRegistered(w, registration)
+}
+
+func respondRegistered(w http.ResponseWriter, registration ClientRegistration) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(ClientRegistrationResponse{
+		ClientRegistration:      registration,
+		GrantTypes:              []string{TokenExchangeGrantType},
+		TokenEndpointAuthMethod: PrivateKeyJwtAuthMethod,
+	})
+}
+
+func signingKeys(key *jose.JSONWebKey) *jwk.SigningKeyStore {
+	return jwk.NewSigningKeyStore(&jwk.SigningKeys{Active: *key, Keys: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{*key}}})
+}
+
 func metadata(baseURL string) *oauth.MetadataOAuth {
 	return &oauth.MetadataOAuth{
 		Issuer:        baseURL,
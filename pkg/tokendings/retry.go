@@ -0,0 +1,66 @@
+package tokendings
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryOptions configures how requests to Tokendings are retried within a single reconcile.
+type RetryOptions struct {
+	// MaxAttempts is the total number of attempts, including the first one. Values below 1 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the upper bound for the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. A Retry-After longer than this ends the retries early.
+	MaxBackoff time.Duration
+}
+
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		MaxAttempts:    3,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+	}
+}
+
+// retry calls fn until it succeeds, fails with a non-retryable error, runs out of attempts, or ctx is done.
+func (o RetryOptions) retry(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || !IsRetryable(err) || attempt+1 >= o.MaxAttempts {
+			return err
+		}
+
+		delay, ok := o.backoff(attempt, RetryAfter(err))
+		if !ok {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt using exponential backoff with full jitter.
+// A Retry-After from Tokendings takes precedence; ok is false if it exceeds MaxBackoff.
+func (o RetryOptions) backoff(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if retryAfter > 0 {
+		return retryAfter, retryAfter <= o.MaxBackoff
+	}
+
+	ceiling := o.InitialBackoff << attempt
+	if ceiling <= 0 || ceiling > o.MaxBackoff {
+		ceiling = o.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0, true
+	}
+	return rand.N(ceiling) + 1, true
+}
@@ -0,0 +1,150 @@
+package tokendings
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+
+	"github.com/nais/jwker/pkg/filewatch"
+)
+
+const (
+	// serviceAccountTokenRefreshBefore is how long before expiry a cached token is read from disk again.
+	// The kubelet rotates projected tokens well before this, at 80% of their lifetime.
+	serviceAccountTokenRefreshBefore = time.Minute
+	// serviceAccountTokenCheckInterval is how often the time to expiry is reported, in addition to on every reload.
+	serviceAccountTokenCheckInterval = 30 * time.Second
+)
+
+// ServiceAccountToken authenticates with a projected Kubernetes service account token.
+// The token is cached in memory, and read from disk again when the file changes or the token is about to expire.
+// An expired token is never used. Tokens without a readable expiry, such as opaque tokens, are used as is until the file changes.
+type ServiceAccountToken struct {
+	Path string
+	// OnExpiry, if set, is called with the time left until the cached token expires whenever it is reloaded,
+	// and periodically while watching. It must not block. It is not called for tokens without a readable expiry.
+	OnExpiry func(time.Duration)
+
+	mu    sync.Mutex
+	token string
+	// expiry is zero if the token's expiry is not known.
+	expiry time.Time
+	now    func() time.Time
+}
+
+func NewServiceAccountToken(path string, onExpiry func(time.Duration)) *ServiceAccountToken {
+	return &ServiceAccountToken{
+		Path:     path,
+		OnExpiry: onExpiry,
+	}
+}
+
+func (a *ServiceAccountToken) Token(_ context.Context, _ string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.clock()
+	if a.token == "" || a.expiresWithin(now, serviceAccountTokenRefreshBefore) {
+		if err := a.reload(); err != nil && (a.token == "" || a.expiresWithin(now, 0)) {
+			return "", err
+		}
+	}
+
+	if a.expiresWithin(now, 0) {
+		return "", fmt.Errorf("service account token from %s expired at %s", a.Path, a.expiry.Format(time.RFC3339))
+	}
+	return a.token, nil
+}
+
+// expiresWithin reports whether the cached token expires within d of now. Tokens without a known expiry never do.
+func (a *ServiceAccountToken) expiresWithin(now time.Time, d time.Duration) bool {
+	return !a.expiry.IsZero() && !now.Before(a.expiry.Add(-d))
+}
+
+// Start watches the token file and reloads the token when it changes, until ctx is done. It implements manager.Runnable.
+func (a *ServiceAccountToken) Start(ctx context.Context) error {
+	reload := func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		// a failed reload keeps the cached token, which is still checked for expiry before use
+		_ = a.reload()
+	}
+	reload()
+
+	go func() {
+		ticker := time.NewTicker(serviceAccountTokenCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.mu.Lock()
+				a.reportExpiry()
+				a.mu.Unlock()
+			}
+		}
+	}()
+
+	return filewatch.Watch(ctx, a.Path, reload)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every replica needs a valid token for its readiness probes.
+func (a *ServiceAccountToken) NeedLeaderElection() bool {
+	return false
+}
+
+// reload reads the token from disk. The cached token is only replaced if the new one can be read.
+func (a *ServiceAccountToken) reload() error {
+	token, err := readTokenFile(a.Path)
+	if err != nil {
+		return err
+	}
+
+	expiry, err := tokenExpiry(token)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("service account token from %s has no readable expiry; using it as is", a.Path), "error", err)
+		expiry = time.Time{}
+	}
+
+	a.token = token
+	a.expiry = expiry
+	a.reportExpiry()
+	return nil
+}
+
+func (a *ServiceAccountToken) reportExpiry() {
+	if a.OnExpiry != nil && a.token != "" && !a.expiry.IsZero() {
+		a.OnExpiry(a.expiry.Sub(a.clock()))
+	}
+}
+
+func (a *ServiceAccountToken) clock() time.Time {
+	if a.now == nil {
+		return time.Now()
+	}
+	return a.now()
+}
+
+// tokenExpiry returns the expiry of a JWT without verifying it; Tokendings verifies the token.
+func tokenExpiry(raw string) (time.Time, error) {
+	token, err := jwt.ParseSigned(raw, []jose.SignatureAlgorithm{jose.RS256, jose.RS384, jose.RS512, jose.ES256, jose.ES384, jose.ES512, jose.PS256, jose.EdDSA})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var claims jwt.Claims
+	if err := token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return time.Time{}, err
+	}
+	if claims.Expiry == nil {
+		return time.Time{}, fmt.Errorf("token has no expiry")
+	}
+	return claims.Expiry.Time(), nil
+}
@@ -0,0 +1,132 @@
+package tokendings
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nais/jwker/pkg/jwk"
+)
+
+func TestServiceAccountToken(t *testing.T) {
+	key, err := jwk.Generate()
+	require.NoError(t, err)
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	signToken := func(t *testing.T, subject string, expiry time.Time) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key.Key}, nil)
+		require.NoError(t, err)
+
+		raw, err := jwt.Signed(signer).Claims(jwt.Claims{Subject: subject, Expiry: jwt.NewNumericDate(expiry)}).Serialize()
+		require.NoError(t, err)
+		return raw
+	}
+
+	path := filepath.Join(t.TempDir(), "token")
+	writeToken := func(t *testing.T, raw string) {
+		require.NoError(t, os.WriteFile(path, []byte(raw), 0o600))
+	}
+
+	var remaining time.Duration
+	newToken := func() *ServiceAccountToken {
+		token := NewServiceAccountToken(path, func(d time.Duration) { remaining = d })
+		token.now = func() time.Time { return now }
+		return token
+	}
+
+	t.Run("token is cached until it is about to expire", func(t *testing.T) {
+		first := signToken(t, "first", now.Add(time.Hour))
+		writeToken(t, first)
+		token := newToken()
+
+		raw, err := token.Token(context.Background(), "http://endpoint")
+		require.NoError(t, err)
+		assert.Equal(t, first, raw)
+		assert.Equal(t, time.Hour, remaining)
+
+		second := signToken(t, "second", now.Add(2*time.Hour))
+		writeToken(t, second)
+
+		raw, err = token.Token(context.Background(), "http://endpoint")
+		require.NoError(t, err)
+		assert.Equal(t, first, raw, "cached token is used")
+
+		now = now.Add(time.Hour - serviceAccountTokenRefreshBefore)
+		raw, err = token.Token(context.Background(), "http://endpoint")
+		require.NoError(t, err)
+		assert.Equal(t, second, raw, "token about to expire is reloaded")
+	})
+
+	t.Run("expired token is rejected", func(t *testing.T) {
+		writeToken(t, signToken(t, "expired", now.Add(-time.Minute)))
+		token := newToken()
+
+		_, err := token.Token(context.Background(), "http://endpoint")
+		assert.ErrorContains(t, err, "expired at")
+		assert.Equal(t, -time.Minute, remaining)
+	})
+
+	t.Run("cached token is used while the file is unreadable", func(t *testing.T) {
+		valid := signToken(t, "valid", now.Add(30*time.Second))
+		writeToken(t, valid)
+		token := newToken()
+
+		_, err := token.Token(context.Background(), "http://endpoint")
+		require.NoError(t, err)
+
+		require.NoError(t, os.Remove(path))
+		raw, err := token.Token(context.Background(), "http://endpoint")
+		require.NoError(t, err)
+		assert.Equal(t, valid, raw)
+
+		now = now.Add(time.Minute)
+		_, err = token.Token(context.Background(), "http://endpoint")
+		assert.Error(t, err)
+	})
+
+	t.Run("opaque token is used without tracking expiry", func(t *testing.T) {
+		writeToken(t, "opaque-token")
+		remaining = 0
+		token := newToken()
+
+		raw, err := token.Token(context.Background(), "http://endpoint")
+		require.NoError(t, err)
+		assert.Equal(t, "opaque-token", raw)
+		assert.Zero(t, remaining, "expiry should not be reported")
+
+		writeToken(t, "other-token")
+		now = now.Add(24 * time.Hour)
+		raw, err = token.Token(context.Background(), "http://endpoint")
+		require.NoError(t, err)
+		assert.Equal(t, "opaque-token", raw, "cached token is used until the file changes")
+	})
+
+	t.Run("token is reloaded when the file changes", func(t *testing.T) {
+		first := signToken(t, "first", now.Add(time.Hour))
+		writeToken(t, first)
+		token := newToken()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan error)
+		go func() { done <- token.Start(ctx) }()
+
+		second := signToken(t, "second", now.Add(2*time.Hour))
+		assert.EventuallyWithT(t, func(c *assert.CollectT) {
+			writeToken(t, second)
+			raw, err := token.Token(context.Background(), "http://endpoint")
+			assert.NoError(c, err)
+			assert.Equal(c, second, raw)
+		}, 5*time.Second, 50*time.Millisecond)
+
+		cancel()
+		assert.NoError(t, <-done)
+	})
+}
@@ -0,0 +1,168 @@
+package tokendings_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	jwkerv1 "github.com/nais/liberator/pkg/apis/nais.io/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nais/jwker/pkg/jwk"
+	"github.com/nais/jwker/pkg/tokendings"
+	"github.com/nais/jwker/pkg/tokendings/tokendingstest"
+)
+
+func TestSyncClient(t *testing.T) {
+	server := tokendingstest.NewServer()
+	defer server.Close()
+
+	signer, err := jwk.Generate()
+	require.NoError(t, err)
+	keys := jwk.NewSigningKeyStore(&jwk.SigningKeys{Active: signer, Keys: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{signer}}})
+	instance := tokendings.NewInstance(server.URL, "jwker", keys, server.Metadata(), "", server.Client())
+
+	key, err := jwk.Generate()
+	require.NoError(t, err)
+	app := tokendings.ClientID{Name: "app1", Namespace: "team1", Cluster: "cluster1"}
+	registration := func(t *testing.T, application string) *tokendings.ClientRegistration {
+		jwker := jwkerv1.Jwker{Spec: jwkerv1.JwkerSpec{AccessPolicy: &jwkerv1.AccessPolicy{
+			Inbound: &jwkerv1.AccessPolicyInbound{Rules: []jwkerv1.AccessPolicyInboundRule{
+				{AccessPolicyRule: jwkerv1.AccessPolicyRule{Application: application}},
+			}},
+		}}}
+		r, err := tokendings.MakeClientRegistration(&signer, &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{key.Public()}}, app, jwker)
+		require.NoError(t, err)
+		return r
+	}
+	expected := registration(t, "app2")
+
+	t.Run("a missing client is registered", func(t *testing.T) {
+		// a missing client is only reported as drift once the instance is known to read clients
+		other := tokendings.ClientID{Name: "other", Namespace: "team1", Cluster: "cluster1"}
+		server.SetRegistration(tokendings.ClientRegistrationResponse{ClientRegistration: tokendings.ClientRegistration{ClientName: other.String()}})
+		_, err := instance.ReadClient(context.Background(), other, nil)
+		require.NoError(t, err)
+		require.True(t, instance.ReadSupport.Known())
+
+		response, drift, err := instance.SyncClient(context.Background(), app, expected, nil)
+		require.NoError(t, err)
+		assert.Equal(t, tokendings.Drift{"client is not registered"}, drift)
+		assert.Equal(t, app.String(), response.ClientName)
+		assert.Equal(t, 1, server.Requests(http.MethodPost))
+	})
+
+	t.Run("a client without drift is left alone", func(t *testing.T) {
+		_, drift, err := instance.SyncClient(context.Background(), app, registration(t, "app2"), nil)
+		require.NoError(t, err)
+		assert.Empty(t, drift)
+		assert.Equal(t, 1, server.Requests(http.MethodPost))
+		assert.Equal(t, 0, server.Requests(http.MethodPut))
+	})
+
+	t.Run("drift in access policy is repaired", func(t *testing.T) {
+		drifted, ok := server.Registration(app.String())
+		require.True(t, ok)
+		drifted.SoftwareStatement = registration(t, "app3").SoftwareStatement
+		server.SetRegistration(drifted)
+
+		_, drift, err := instance.SyncClient(context.Background(), app, expected, nil)
+		require.NoError(t, err)
+		assert.Equal(t, tokendings.Drift{
+			"inbound access policy is missing [cluster1:team1:app2]",
+			"inbound access policy has unexpected [cluster1:team1:app3]",
+		}, drift)
+		assert.Equal(t, 1, server.Requests(http.MethodPut))
+
+		repaired, _ := server.Registration(app.String())
+		assert.Equal(t, expected.SoftwareStatement, repaired.SoftwareStatement)
+	})
+
+	t.Run("drift in keys is repaired", func(t *testing.T) {
+		other, err := jwk.Generate()
+		require.NoError(t, err)
+
+		drifted, _ := server.Registration(app.String())
+		drifted.Jwks = jose.JSONWebKeySet{Keys: []jose.JSONWebKey{other.Public()}}
+		server.SetRegistration(drifted)
+
+		response, drift, err := instance.SyncClient(context.Background(), app, expected, nil)
+		require.NoError(t, err)
+		assert.Len(t, drift, 2)
+		assert.Equal(t, []string{key.KeyID}, []string{response.Jwks.Keys[0].KeyID})
+		assert.Equal(t, 2, server.Requests(http.MethodPut))
+	})
+
+	t.Run("clients are registered with instances that cannot read them", func(t *testing.T) {
+		legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			server.Config.Handler.ServeHTTP(w, r)
+		}))
+		defer legacy.Close()
+
+		legacyInstance := tokendings.NewInstance(legacy.URL, "jwker", keys, server.Metadata(), "", legacy.Client())
+		legacyInstance.Retry = tokendings.RetryOptions{MaxAttempts: 1, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+		_, drift, err := legacyInstance.SyncClient(context.Background(), app, expected, nil)
+		require.NoError(t, err)
+		assert.Empty(t, drift)
+		assert.Equal(t, 2, server.Requests(http.MethodPost))
+	})
+
+	t.Run("clients are registered without drift with instances without the read route", func(t *testing.T) {
+		legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				http.NotFound(w, r)
+				return
+			}
+			server.Config.Handler.ServeHTTP(w, r)
+		}))
+		defer legacy.Close()
+
+		legacyInstance := tokendings.NewInstance(legacy.URL, "jwker", keys, server.Metadata(), "", legacy.Client())
+		legacyInstance.Retry = tokendings.RetryOptions{MaxAttempts: 1, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+		posts := server.Requests(http.MethodPost)
+		for range 2 {
+			_, drift, err := legacyInstance.SyncClient(context.Background(), app, expected, nil)
+			require.NoError(t, err)
+			assert.Empty(t, drift, "a missing read route should not be reported as drift")
+		}
+		assert.Equal(t, posts+2, server.Requests(http.MethodPost))
+		assert.False(t, legacyInstance.ReadSupport.Known())
+	})
+
+	t.Run("drift is repaired by registering with instances that cannot update clients", func(t *testing.T) {
+		legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			server.Config.Handler.ServeHTTP(w, r)
+		}))
+		defer legacy.Close()
+
+		legacyInstance := tokendings.NewInstance(legacy.URL, "jwker", keys, server.Metadata(), "", legacy.Client())
+		legacyInstance.Retry = tokendings.RetryOptions{MaxAttempts: 1, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+		drifted, _ := server.Registration(app.String())
+		drifted.SoftwareStatement = registration(t, "app3").SoftwareStatement
+		server.SetRegistration(drifted)
+
+		posts := server.Requests(http.MethodPost)
+		_, drift, err := legacyInstance.SyncClient(context.Background(), app, expected, nil)
+		require.NoError(t, err)
+		assert.Len(t, drift, 2)
+		assert.Equal(t, posts+1, server.Requests(http.MethodPost))
+
+		repaired, _ := server.Registration(app.String())
+		assert.Equal(t, expected.SoftwareStatement, repaired.SoftwareStatement)
+	})
+}
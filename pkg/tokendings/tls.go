@@ -0,0 +1,199 @@
+package tokendings
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nais/jwker/pkg/filewatch"
+)
+
+// TLSOptions configures TLS for requests to a Tokendings instance. The zero value uses Go's defaults.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of certificate authorities that replaces the system roots.
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key for mutual TLS. They are reloaded when the files change.
+	CertFile string
+	KeyFile  string
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS13. Zero uses Go's default.
+	MinVersion uint16
+	// ServerName overrides the name used to verify the server's certificate.
+	ServerName string
+}
+
+// Config returns the TLS configuration for the options, and the client certificate if one is configured.
+// onReload, if set, is called with the outcome of every reload of the client certificate.
+func (o TLSOptions) Config(onReload func(error)) (*tls.Config, *ClientCertificate, error) {
+	config := &tls.Config{
+		MinVersion: o.MinVersion,
+		ServerName: o.ServerName,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if o.CAFile != "" {
+		bundle, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, nil, fmt.Errorf("no certificates found in CA bundle %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if o.CertFile == "" && o.KeyFile == "" {
+		return config, nil, nil
+	}
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, nil, fmt.Errorf("both a client certificate and key are required for mutual TLS")
+	}
+
+	certificate, err := LoadClientCertificate(o.CertFile, o.KeyFile, onReload)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.GetClientCertificate = certificate.GetClientCertificate
+	return config, certificate, nil
+}
+
+// ClientCertificate is a TLS client certificate that is reloaded when its files change,
+// so that a rotated certificate is used for new connections without a restart.
+type ClientCertificate struct {
+	CertFile string
+	KeyFile  string
+	// OnReload, if set, is called with the outcome of every reload while watching.
+	OnReload func(error)
+
+	current atomic.Pointer[tls.Certificate]
+}
+
+// LoadClientCertificate loads the certificate and key in PEM format from certFile and keyFile.
+func LoadClientCertificate(certFile, keyFile string, onReload func(error)) (*ClientCertificate, error) {
+	c := &ClientCertificate{CertFile: certFile, KeyFile: keyFile, OnReload: onReload}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the certificate and key again. The current certificate is kept if they cannot be loaded,
+// e.g. if only one of the files has been replaced so far.
+func (c *ClientCertificate) Reload() error {
+	certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading client certificate from %s and %s: %w", c.CertFile, c.KeyFile, err)
+	}
+	c.current.Store(&certificate)
+	return nil
+}
+
+// GetClientCertificate returns the current certificate. It is used as tls.Config.GetClientCertificate.
+func (c *ClientCertificate) GetClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	certificate := c.current.Load()
+	if certificate == nil {
+		return nil, fmt.Errorf("no client certificate loaded from %s", c.CertFile)
+	}
+	return certificate, nil
+}
+
+// Start reloads the certificate whenever the certificate or key file changes, until ctx is done. It implements manager.Runnable.
+func (c *ClientCertificate) Start(ctx context.Context) error {
+	reload := func() {
+		err := c.Reload()
+		if c.OnReload != nil {
+			c.OnReload(err)
+		}
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- filewatch.Watch(ctx, c.CertFile, reload) }()
+	go func() { errs <- filewatch.Watch(ctx, c.KeyFile, reload) }()
+
+	for range 2 {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every replica connects to Tokendings, e.g. in readiness probes.
+func (c *ClientCertificate) NeedLeaderElection() bool {
+	return false
+}
+
+// ParseTLSVersion parses a TLS version such as "1.2" or "1.3".
+func ParseTLSVersion(s string) (uint16, error) {
+	switch strings.TrimSpace(s) {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q; must be one of '1.2' or '1.3'", s)
+	}
+}
+
+// ParseTLSOptions parses a comma separated list of baseURL=options pairs, where options is a semicolon separated list of
+//
+//	ca-file=<path>
+//	cert-file=<path>
+//	key-file=<path>
+//	min-version=<1.2|1.3>
+//	server-name=<name>
+func ParseTLSOptions(s string) (map[string]TLSOptions, error) {
+	pairs, err := parsePairs(s, "TLS options", "baseURL=options")
+	if err != nil {
+		return nil, err
+	}
+
+	options := make(map[string]TLSOptions, len(pairs))
+	for _, p := range pairs {
+		baseURL := p.key
+
+		var o TLSOptions
+		for setting := range strings.SplitSeq(p.value, ";") {
+			setting = strings.TrimSpace(setting)
+			if setting == "" {
+				continue
+			}
+
+			key, value, _ := strings.Cut(setting, "=")
+			value = strings.TrimSpace(value)
+			if value == "" {
+				return nil, fmt.Errorf("tokendings instance %s: TLS option %q requires a value", baseURL, setting)
+			}
+
+			switch strings.TrimSpace(key) {
+			case "ca-file":
+				o.CAFile = value
+			case "cert-file":
+				o.CertFile = value
+			case "key-file":
+				o.KeyFile = value
+			case "min-version":
+				version, err := ParseTLSVersion(value)
+				if err != nil {
+					return nil, fmt.Errorf("tokendings instance %s: %w", baseURL, err)
+				}
+				o.MinVersion = version
+			case "server-name":
+				o.ServerName = value
+			default:
+				return nil, fmt.Errorf("tokendings instance %s: unknown TLS option %q; must be one of 'ca-file', 'cert-file', 'key-file', 'min-version' or 'server-name'", baseURL, key)
+			}
+		}
+		if (o.CertFile == "") != (o.KeyFile == "") {
+			return nil, fmt.Errorf("tokendings instance %s: both 'cert-file' and 'key-file' are required for mutual TLS", baseURL)
+		}
+		options[baseURL] = o
+	}
+	return options, nil
+}
@@ -0,0 +1,186 @@
+package tokendings
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nais/liberator/pkg/oauth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTLSOptions(t *testing.T) {
+	options, err := ParseTLSOptions("https://a=ca-file=/etc/ca.pem; min-version=1.3, https://b=cert-file=/etc/tls.crt;key-file=/etc/tls.key;server-name=tokendings.internal")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]TLSOptions{
+		"https://a": {CAFile: "/etc/ca.pem", MinVersion: tls.VersionTLS13},
+		"https://b": {CertFile: "/etc/tls.crt", KeyFile: "/etc/tls.key", ServerName: "tokendings.internal"},
+	}, options)
+
+	for _, invalid := range []string{
+		"https://a",
+		"https://a=unknown=value",
+		"https://a=ca-file=",
+		"https://a=min-version=1.1",
+		"https://a=cert-file=/etc/tls.crt",
+		"https://a=min-version=1.2,https://a=server-name=tokendings.internal",
+	} {
+		_, err := ParseTLSOptions(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestTLSOptions(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	clientCert := writeClientCertificate(t, certFile, keyFile, "jwker")
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	var clientNames []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientNames = append(clientNames, r.TLS.PeerCertificates[0].Subject.CommonName)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(metadata("https://tokendings.internal"))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+
+	t.Run("custom CA without client certificate is rejected by the server", func(t *testing.T) {
+		config, certificate, err := TLSOptions{CAFile: caFile}.Config(nil)
+		require.NoError(t, err)
+		assert.Nil(t, certificate)
+
+		client := NewHTTPClient(HTTPOptions{Timeout: time.Second, TLS: config})
+		_, err = client.Get(server.URL)
+		assert.Error(t, err)
+	})
+
+	t.Run("metadata is fetched with mutual TLS", func(t *testing.T) {
+		config, certificate, err := TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, MinVersion: tls.VersionTLS13}.Config(nil)
+		require.NoError(t, err)
+		require.NotNil(t, certificate)
+
+		m := NewMetadata(server.URL+oauth.WellKnownOAuthSuffix, nil)
+		m.HTTPClient = NewHTTPClient(HTTPOptions{Timeout: time.Second, TLS: config})
+		require.NoError(t, m.Resolve(context.Background()))
+		assert.Equal(t, "https://tokendings.internal", m.Get().Issuer)
+		assert.Equal(t, []string{"jwker"}, clientNames)
+	})
+
+	t.Run("server name override", func(t *testing.T) {
+		// the test server's certificate is valid for example.com
+		config, _, err := TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "example.com"}.Config(nil)
+		require.NoError(t, err)
+
+		resp, err := NewHTTPClient(HTTPOptions{Timeout: time.Second, TLS: config}).Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		config, _, err = TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "tokendings.invalid"}.Config(nil)
+		require.NoError(t, err)
+
+		_, err = NewHTTPClient(HTTPOptions{Timeout: time.Second, TLS: config}).Get(server.URL)
+		assert.ErrorContains(t, err, "tokendings.invalid")
+	})
+
+	t.Run("invalid CA bundle", func(t *testing.T) {
+		_, _, err := TLSOptions{CAFile: certFile + ".missing"}.Config(nil)
+		assert.Error(t, err)
+
+		_, _, err = TLSOptions{CAFile: keyFile}.Config(nil)
+		assert.ErrorContains(t, err, "no certificates found")
+	})
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeClientCertificate(t, certFile, keyFile, "first")
+
+	reloads := make(chan error, 10)
+	certificate, err := LoadClientCertificate(certFile, keyFile, func(err error) { reloads <- err })
+	require.NoError(t, err)
+	assert.Equal(t, "first", commonName(t, certificate))
+
+	t.Run("a broken pair keeps the current certificate", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0o600))
+		assert.Error(t, certificate.Reload())
+		assert.Equal(t, "first", commonName(t, certificate))
+	})
+
+	t.Run("changed files are reloaded while started", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- certificate.Start(ctx) }()
+
+		assert.EventuallyWithT(t, func(c *assert.CollectT) {
+			writeClientCertificate(t, certFile, keyFile, "second")
+			assert.Equal(c, "second", commonName(t, certificate))
+		}, 5*time.Second, 50*time.Millisecond)
+		assert.NotEmpty(t, reloads)
+
+		cancel()
+		assert.NoError(t, <-done)
+	})
+}
+
+func commonName(t *testing.T, certificate *ClientCertificate) string {
+	current, err := certificate.GetClientCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(current.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+// writeClientCertificate writes a self-signed client certificate and its key in PEM format.
+func writeClientCertificate(t *testing.T, certFile, keyFile, commonName string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return certificate
+}
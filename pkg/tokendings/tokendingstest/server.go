@@ -0,0 +1,167 @@
+// Package tokendingstest provides an in-memory stand-in for Tokendings, for tests of code that registers clients.
+package tokendingstest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/nais/liberator/pkg/oauth"
+
+	"github.com/nais/jwker/pkg/tokendings"
+)
+
+// Server emulates the client registration API of Tokendings: clients are registered at /registration/client, and
+// read, updated and deleted at /registration/client/{id}, where id is the client name. Any bearer token is accepted.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	clients  map[string]tokendings.ClientRegistrationResponse
+	requests map[string]int
+}
+
+// NewServer starts and returns a new Server. The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		clients:  make(map[string]tokendings.ClientRegistrationResponse),
+		requests: make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+oauth.WellKnownOAuthSuffix, s.serveMetadata)
+	mux.HandleFunc("POST /registration/client", s.authenticated(s.serveRegister))
+	mux.HandleFunc("GET /registration/client/{id}", s.authenticated(s.serveRead))
+	mux.HandleFunc("PUT /registration/client/{id}", s.authenticated(s.serveUpdate))
+	mux.HandleFunc("DELETE /registration/client/{id}", s.authenticated(s.serveDelete))
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Metadata returns the authorization server metadata of the server.
+func (s *Server) Metadata() *oauth.MetadataOAuth {
+	return &oauth.MetadataOAuth{
+		Issuer:        s.URL,
+		JwksURI:       s.URL + "/jwks",
+		TokenEndpoint: s.URL + "/token",
+	}
+}
+
+// Registration returns the client registered with the given ID, if any.
+func (s *Server) Registration(id string) (tokendings.ClientRegistrationResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[id]
+	return client, ok
+}
+
+// SetRegistration replaces the client with the same name, e.g. to make it drift from what was registered.
+func (s *Server) SetRegistration(client tokendings.ClientRegistrationResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clients[client.ClientName] = client
+}
+
+// DeleteRegistration removes the client with the given ID, if any.
+func (s *Server) DeleteRegistration(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.clients, id)
+}
+
+// Requests returns the number of authenticated requests received with the given method.
+func (s *Server) Requests(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[method]
+}
+
+func (s *Server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		s.mu.Lock()
+		s.requests[r.Method]++
+		s.mu.Unlock()
+
+		next(w, r)
+	}
+}
+
+func (s *Server) serveMetadata(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.Metadata())
+}
+
+func (s *Server) serveRegister(w http.ResponseWriter, r *http.Request) {
+	var registration tokendings.ClientRegistration
+	if err := json.NewDecoder(r.Body).Decode(&registration); err != nil || registration.ClientName == "" {
+		http.Error(w, "invalid client registration", http.StatusBadRequest)
+		return
+	}
+
+	s.SetRegistration(registered(registration))
+	s.respond(w, http.StatusCreated, registration.ClientName)
+}
+
+func (s *Server) serveRead(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.Registration(r.PathValue("id")); !ok {
+		http.Error(w, "client not found", http.StatusNotFound)
+		return
+	}
+	s.respond(w, http.StatusOK, r.PathValue("id"))
+}
+
+func (s *Server) serveUpdate(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.Registration(id); !ok {
+		http.Error(w, "client not found", http.StatusNotFound)
+		return
+	}
+
+	var registration tokendings.ClientRegistration
+	if err := json.NewDecoder(r.Body).Decode(&registration); err != nil || registration.ClientName != id {
+		http.Error(w, "invalid client registration", http.StatusBadRequest)
+		return
+	}
+
+	s.SetRegistration(registered(registration))
+	s.respond(w, http.StatusOK, id)
+}
+
+func (s *Server) serveDelete(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.Registration(r.PathValue("id")); !ok {
+		http.Error(w, "client not found", http.StatusNotFound)
+		return
+	}
+
+	s.DeleteRegistration(r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) respond(w http.ResponseWriter, status int, id string) {
+	client, _ := s.Registration(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(client)
+}
+
+func registered(registration tokendings.ClientRegistration) tokendings.ClientRegistrationResponse {
+	return tokendings.ClientRegistrationResponse{
+		ClientRegistration:      registration,
+		GrantTypes:              []string{tokendings.TokenExchangeGrantType},
+		TokenEndpointAuthMethod: tokendings.PrivateKeyJwtAuthMethod,
+	}
+}